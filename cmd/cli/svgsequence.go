@@ -3,9 +3,13 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 
 	svgsequence "github.com/aorith/svg-sequence"
 )
@@ -14,6 +18,7 @@ func main() {
 	var (
 		inputFile  = flag.String("i", "", "Input CFG file (required)")
 		outputFile = flag.String("o", "", "Output SVG file (default: stdout)")
+		format     = flag.String("format", "text", "Error output format: text or json")
 	)
 
 	flag.Usage = func() {
@@ -27,24 +32,79 @@ func main() {
 
 	flag.Parse()
 
-	if *inputFile == "" {
+	if *inputFile == "" || (*format != "text" && *format != "json") {
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	svg, err := svgsequence.GenerateFromCFG(*inputFile)
+	if err := run(*inputFile, *outputFile, os.Stdout); err != nil {
+		writeError(os.Stderr, *format, err)
+		os.Exit(1)
+	}
+	if *outputFile != "" {
+		fmt.Fprintf(os.Stderr, "Sequence written to %s\n", *outputFile)
+	}
+}
+
+// run generates the sequence from the input file and streams it to the output file,
+// or to stdout if no output file is given
+func run(inputFile, outputFile string, stdout io.Writer) error {
+	s, err := svgsequence.ParseCFG(inputFile)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	// Write output
-	if *outputFile == "" {
-		fmt.Println(svg)
-	} else {
-		if err := os.WriteFile(*outputFile, []byte(svg), 0o644); err != nil {
-			fmt.Fprintf(os.Stderr, "Error writing output file: %v\n", err)
-			os.Exit(1)
+	if outputFile == "" {
+		if err := s.GenerateTo(stdout); err != nil {
+			return err
 		}
-		fmt.Fprintf(os.Stderr, "Sequence written to %s\n", *outputFile)
+		_, err := fmt.Fprintln(stdout)
+		return err
+	}
+
+	// generate into a temporary file next to the output file, so an error
+	// does not clobber an existing output file
+	f, err := os.CreateTemp(filepath.Dir(outputFile), ".svgsequence-*.svg")
+	if err != nil {
+		return fmt.Errorf("error writing output file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	if err := s.GenerateTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(0o644); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing output file: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("error writing output file: %v", err)
+	}
+	if err := os.Rename(f.Name(), outputFile); err != nil {
+		return fmt.Errorf("error writing output file: %v", err)
+	}
+	return nil
+}
+
+// jsonError is the structured error printed with -format=json
+type jsonError struct {
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
+}
+
+// writeError reports err to w in the given format, parse errors include their line number
+func writeError(w io.Writer, format string, err error) {
+	if format != "json" {
+		fmt.Fprintf(w, "Error: %v\n", err)
+		return
+	}
+
+	e := jsonError{Message: err.Error()}
+	var perr *svgsequence.ParseError
+	if errors.As(err, &perr) {
+		e.Line = perr.Line
+		e.Message = perr.Message
 	}
+	json.NewEncoder(w).Encode(e)
 }
@@ -0,0 +1,113 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunLargeDiagram(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "large.cfg")
+	output := filepath.Join(dir, "large.svg")
+
+	var cfg strings.Builder
+	for i := range 5000 {
+		fmt.Fprintf(&cfg, "@step A%d, A%d, step %d\n", i%10, (i+1)%10, i)
+	}
+	if err := os.WriteFile(input, []byte(cfg.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout bytes.Buffer
+	if err := run(input, output, &stdout); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("run() with an output file wrote %d bytes to stdout", stdout.Len())
+	}
+
+	got, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(got, []byte("<svg")) || !bytes.HasSuffix(got, []byte("</svg>")) {
+		t.Errorf("run() output file is not a complete SVG")
+	}
+	if n := bytes.Count(got, []byte(`class="seq-desc"`)); n != 5000 {
+		t.Errorf("run() output file has %d descriptions, want 5000", n)
+	}
+}
+
+func TestWriteErrorJSON(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "bad.cfg")
+	if err := os.WriteFile(input, []byte("@step A, B, hi\n@step A\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout bytes.Buffer
+	err := run(input, "", &stdout)
+	if err == nil {
+		t.Fatal("run() with an invalid file did not fail")
+	}
+
+	var stderr bytes.Buffer
+	writeError(&stderr, "json", err)
+	want := `{"line":2,"message":"not enough values for step"}` + "\n"
+	if stderr.String() != want {
+		t.Errorf("writeError() = %q, want %q", stderr.String(), want)
+	}
+
+	stderr.Reset()
+	writeError(&stderr, "text", err)
+	if want := "Error: not enough values for step at line 2\n"; stderr.String() != want {
+		t.Errorf("writeError() = %q, want %q", stderr.String(), want)
+	}
+
+	stderr.Reset()
+	writeError(&stderr, "json", run(filepath.Join(dir, "missing.cfg"), "", &stdout))
+	if !strings.HasPrefix(stderr.String(), `{"message":"error reading file`) {
+		t.Errorf("writeError() = %q, want a message without line", stderr.String())
+	}
+}
+
+func TestRunKeepsOutputOnError(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "out.svg")
+	if err := os.WriteFile(output, []byte("previous"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// parse error and generate error
+	for i, cfg := range []string{"@step A\n", "@start Open\n@step A, B\n"} {
+		input := filepath.Join(dir, fmt.Sprintf("bad%d.cfg", i))
+		if err := os.WriteFile(input, []byte(cfg), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		var stdout bytes.Buffer
+		if err := run(input, output, &stdout); err == nil {
+			t.Fatalf("run() with %q did not fail", cfg)
+		}
+		got, err := os.ReadFile(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != "previous" {
+			t.Errorf("run() with %q changed the output file to %q", cfg, got)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Errorf("run() left %d files in the output directory, want 3", len(entries))
+	}
+}
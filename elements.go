@@ -4,6 +4,8 @@ package svgsequence
 
 import (
 	"encoding/xml"
+	"reflect"
+	"slices"
 )
 
 type svg struct {
@@ -21,6 +23,7 @@ type svg struct {
 type svgDefs struct {
 	XMLName  xml.Name `xml:"defs"`
 	Elements []any    `xml:",any"`
+	Raw      string   `xml:",innerxml"`
 }
 
 type svgStyle struct {
@@ -29,82 +32,208 @@ type svgStyle struct {
 }
 
 type rect struct {
-	XMLName     xml.Name `xml:"rect"`
-	ID          string   `xml:"id,attr,omitempty"`
-	Class       string   `xml:"class,attr,omitempty"`
-	X           float64  `xml:"x,attr"`
-	Y           float64  `xml:"y,attr"`
-	Width       float64  `xml:"width,attr"`
-	Height      float64  `xml:"height,attr"`
-	Fill        string   `xml:"fill,attr,omitempty"`
-	FillOpacity float64  `xml:"fill-opacity,attr,omitempty"`
-	Stroke      string   `xml:"stroke,attr,omitempty"`
-	StrokeWidth int      `xml:"stroke-width,attr,omitempty"`
+	XMLName         xml.Name   `xml:"rect"`
+	ID              string     `xml:"id,attr,omitempty"`
+	Class           string     `xml:"class,attr,omitempty"`
+	X               float64    `xml:"x,attr"`
+	Y               float64    `xml:"y,attr"`
+	Width           float64    `xml:"width,attr"`
+	Height          float64    `xml:"height,attr"`
+	Fill            string     `xml:"fill,attr,omitempty"`
+	FillOpacity     float64    `xml:"fill-opacity,attr,omitempty"`
+	Stroke          string     `xml:"stroke,attr,omitempty"`
+	StrokeWidth     int        `xml:"stroke-width,attr,omitempty"`
+	StrokeDasharray string     `xml:"stroke-dasharray,attr,omitempty"`
+	Attrs           []xml.Attr `xml:",any,attr"`
 }
 
 type line struct {
-	XMLName         xml.Name `xml:"line"`
-	ID              string   `xml:"id,attr,omitempty"`
-	Class           string   `xml:"class,attr,omitempty"`
-	X1              float64  `xml:"x1,attr"`
-	Y1              float64  `xml:"y1,attr"`
-	X2              float64  `xml:"x2,attr"`
-	Y2              float64  `xml:"y2,attr"`
-	Fill            string   `xml:"fill,attr,omitempty"`
-	Stroke          string   `xml:"stroke,attr,omitempty"`
-	StrokeWidth     int      `xml:"stroke-width,attr,omitempty"`
-	StrokeDasharray string   `xml:"stroke-dasharray,attr,omitempty"`
-	MarkerStart     string   `xml:"marker-start,attr,omitempty"`
-	MarkerEnd       string   `xml:"marker-end,attr,omitempty"`
+	XMLName         xml.Name   `xml:"line"`
+	ID              string     `xml:"id,attr,omitempty"`
+	Class           string     `xml:"class,attr,omitempty"`
+	X1              float64    `xml:"x1,attr"`
+	Y1              float64    `xml:"y1,attr"`
+	X2              float64    `xml:"x2,attr"`
+	Y2              float64    `xml:"y2,attr"`
+	Fill            string     `xml:"fill,attr,omitempty"`
+	Stroke          string     `xml:"stroke,attr,omitempty"`
+	StrokeWidth     int        `xml:"stroke-width,attr,omitempty"`
+	StrokeDasharray string     `xml:"stroke-dasharray,attr,omitempty"`
+	StrokeLinecap   string     `xml:"stroke-linecap,attr,omitempty"`
+	MarkerStart     string     `xml:"marker-start,attr,omitempty"`
+	MarkerEnd       string     `xml:"marker-end,attr,omitempty"`
+	Attrs           []xml.Attr `xml:",any,attr"`
 }
 
 type text struct {
-	XMLName     xml.Name `xml:"text"`
-	ID          string   `xml:"id,attr,omitempty"`
-	Class       string   `xml:"class,attr,omitempty"`
-	X           float64  `xml:"x,attr"`
-	Y           float64  `xml:"y,attr"`
-	Fill        string   `xml:"fill,attr,omitempty"`
-	Stroke      string   `xml:"stroke,attr,omitempty"`
-	FontSize    string   `xml:"font-size,attr,omitempty"`
-	TextAnchor  string   `xml:"text-anchor,attr,omitempty"`
-	WritingMode string   `xml:"writing-mode,attr,omitempty"`
-	Transform   string   `xml:"transform,attr,omitempty"`
-	Content     string   `xml:",chardata"`
+	XMLName     xml.Name   `xml:"text"`
+	ID          string     `xml:"id,attr,omitempty"`
+	Class       string     `xml:"class,attr,omitempty"`
+	X           float64    `xml:"x,attr"`
+	Y           float64    `xml:"y,attr"`
+	Fill        string     `xml:"fill,attr,omitempty"`
+	FillOpacity *float64   `xml:"fill-opacity,attr,omitempty"`
+	Stroke      string     `xml:"stroke,attr,omitempty"`
+	FontSize    string     `xml:"font-size,attr,omitempty"`
+	FontStyle   string     `xml:"font-style,attr,omitempty"`
+	TextAnchor  string     `xml:"text-anchor,attr,omitempty"`
+	WritingMode string     `xml:"writing-mode,attr,omitempty"`
+	Transform   string     `xml:"transform,attr,omitempty"`
+	XMLSpace    string     `xml:"http://www.w3.org/XML/1998/namespace space,attr,omitempty"`
+	Attrs       []xml.Attr `xml:",any,attr"`
+	Content     string     `xml:",chardata"`
 }
 
 type marker struct {
-	XMLName      xml.Name `xml:"marker"`
-	ID           string   `xml:"id,attr,omitempty"`
-	Class        string   `xml:"class,attr,omitempty"`
-	ViewBox      string   `xml:"viewBox,attr"`
-	MarkerWidth  float64  `xml:"markerWidth,attr,omitempty"`
-	MarkerHeight float64  `xml:"markerHeight,attr,omitempty"`
-	RefX         float64  `xml:"refX,attr,omitempty"`
-	RefY         float64  `xml:"refY,attr,omitempty"`
-	Orient       string   `xml:"orient,attr,omitempty"`
-	Elements     []any    `xml:",any"`
+	XMLName      xml.Name   `xml:"marker"`
+	ID           string     `xml:"id,attr,omitempty"`
+	Class        string     `xml:"class,attr,omitempty"`
+	ViewBox      string     `xml:"viewBox,attr"`
+	MarkerWidth  float64    `xml:"markerWidth,attr,omitempty"`
+	MarkerHeight float64    `xml:"markerHeight,attr,omitempty"`
+	RefX         float64    `xml:"refX,attr,omitempty"`
+	RefY         float64    `xml:"refY,attr,omitempty"`
+	Orient       string     `xml:"orient,attr,omitempty"`
+	Attrs        []xml.Attr `xml:",any,attr"`
+	Elements     []any      `xml:",any"`
 }
 
 type path struct {
-	XMLName     xml.Name `xml:"path"`
-	ID          string   `xml:"id,attr,omitempty"`
-	Class       string   `xml:"class,attr,omitempty"`
-	D           string   `xml:"d,attr"`
-	Fill        string   `xml:"fill,attr,omitempty"`
-	Stroke      string   `xml:"stroke,attr,omitempty"`
-	StrokeWidth float64  `xml:"stroke-width,attr,omitempty"`
-	MarkerEnd   string   `xml:"marker-end,attr,omitempty"`
-	MarkerStart string   `xml:"marker-start,attr,omitempty"`
+	XMLName       xml.Name   `xml:"path"`
+	ID            string     `xml:"id,attr,omitempty"`
+	Class         string     `xml:"class,attr,omitempty"`
+	D             string     `xml:"d,attr"`
+	Fill          string     `xml:"fill,attr,omitempty"`
+	Stroke        string     `xml:"stroke,attr,omitempty"`
+	StrokeWidth   float64    `xml:"stroke-width,attr,omitempty"`
+	StrokeLinecap string     `xml:"stroke-linecap,attr,omitempty"`
+	MarkerEnd     string     `xml:"marker-end,attr,omitempty"`
+	MarkerStart   string     `xml:"marker-start,attr,omitempty"`
+	Attrs         []xml.Attr `xml:",any,attr"`
 }
 
 type circle struct {
-	XMLName xml.Name `xml:"circle"`
-	ID      string   `xml:"id,attr,omitempty"`
-	Class   string   `xml:"class,attr,omitempty"`
-	CX      float64  `xml:"cx,attr"`
-	CY      float64  `xml:"cy,attr"`
-	R       int      `xml:"r,attr"`
-	Fill    string   `xml:"fill,attr,omitempty"`
-	Stroke  string   `xml:"stroke,attr,omitempty"`
+	XMLName xml.Name   `xml:"circle"`
+	ID      string     `xml:"id,attr,omitempty"`
+	Class   string     `xml:"class,attr,omitempty"`
+	CX      float64    `xml:"cx,attr"`
+	CY      float64    `xml:"cy,attr"`
+	R       int        `xml:"r,attr"`
+	Fill    string     `xml:"fill,attr,omitempty"`
+	Stroke  string     `xml:"stroke,attr,omitempty"`
+	Attrs   []xml.Attr `xml:",any,attr"`
+}
+
+type ellipse struct {
+	XMLName     xml.Name   `xml:"ellipse"`
+	ID          string     `xml:"id,attr,omitempty"`
+	Class       string     `xml:"class,attr,omitempty"`
+	CX          float64    `xml:"cx,attr"`
+	CY          float64    `xml:"cy,attr"`
+	RX          float64    `xml:"rx,attr"`
+	RY          float64    `xml:"ry,attr"`
+	Fill        string     `xml:"fill,attr,omitempty"`
+	Stroke      string     `xml:"stroke,attr,omitempty"`
+	StrokeWidth float64    `xml:"stroke-width,attr,omitempty"`
+	Attrs       []xml.Attr `xml:",any,attr"`
+}
+
+type group struct {
+	XMLName   xml.Name   `xml:"g"`
+	ID        string     `xml:"id,attr,omitempty"`
+	Class     string     `xml:"class,attr,omitempty"`
+	Transform string     `xml:"transform,attr,omitempty"`
+	Opacity   float64    `xml:"opacity,attr,omitempty"`
+	Attrs     []xml.Attr `xml:",any,attr"`
+	Elements  []any      `xml:",any"`
+}
+
+type use struct {
+	XMLName   xml.Name   `xml:"use"`
+	ID        string     `xml:"id,attr,omitempty"`
+	Class     string     `xml:"class,attr,omitempty"`
+	Href      string     `xml:"href,attr"`
+	X         float64    `xml:"x,attr"`
+	Y         float64    `xml:"y,attr"`
+	Fill      string     `xml:"fill,attr,omitempty"`
+	Stroke    string     `xml:"stroke,attr,omitempty"`
+	Transform string     `xml:"transform,attr,omitempty"`
+	Attrs     []xml.Attr `xml:",any,attr"`
+}
+
+type metadata struct {
+	XMLName  xml.Name `xml:"metadata"`
+	Elements []any    `xml:",any"`
+	Content  string   `xml:",cdata"`
+}
+
+type meta struct {
+	XMLName xml.Name `xml:"meta"`
+	Name    string   `xml:"name,attr"`
+	Content string   `xml:",chardata"`
+}
+
+type title struct {
+	XMLName xml.Name `xml:"title"`
+	Content string   `xml:",chardata"`
+}
+
+// ElementName returns the SVG tag of an element passed to the element hook, e.g. "line", "text" or "g"
+func ElementName(el any) string {
+	v := reflect.ValueOf(el)
+	if v.Kind() != reflect.Struct {
+		return ""
+	}
+	// the elements are created without name, it is the tag of the XMLName field
+	f, ok := v.Type().FieldByName("XMLName")
+	if !ok {
+		return ""
+	}
+	return f.Tag.Get("xml")
+}
+
+// AddElementClass returns a copy of an element passed to the element hook with the CSS class
+// added to its classes, or the element unchanged if it has no classes, like the <style> element
+func AddElementClass(el any, class string) any {
+	return updateElement(el, "Class", func(f reflect.Value) {
+		if f.String() == "" {
+			f.SetString(class)
+		} else {
+			f.SetString(f.String() + " " + class)
+		}
+	})
+}
+
+// SetElementAttr returns a copy of an element passed to the element hook with an additional
+// attribute, e.g. a "data-*" attribute, or the element unchanged if it has no additional attributes.
+//
+// Setting an attribute that the element already writes, like "fill", duplicates it.
+func SetElementAttr(el any, name, value string) any {
+	return updateElement(el, "Attrs", func(f reflect.Value) {
+		attrs := slices.Clone(f.Interface().([]xml.Attr))
+		i := slices.IndexFunc(attrs, func(a xml.Attr) bool { return a.Name.Local == name })
+		if i < 0 {
+			attrs = append(attrs, xml.Attr{Name: xml.Name{Local: name}, Value: value})
+		} else {
+			attrs[i].Value = value
+		}
+		f.Set(reflect.ValueOf(attrs))
+	})
+}
+
+// updateElement returns a copy of the element with the field updated,
+// or the element unchanged if it does not have the field
+func updateElement(el any, field string, update func(reflect.Value)) any {
+	v := reflect.ValueOf(el)
+	if v.Kind() != reflect.Struct {
+		return el
+	}
+	elem := reflect.New(v.Type()).Elem()
+	elem.Set(v)
+	f := elem.FieldByName(field)
+	if !f.IsValid() {
+		return el
+	}
+	update(f)
+	return elem.Interface()
 }
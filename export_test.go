@@ -0,0 +1,10 @@
+// SPDX-License-Identifier: MIT
+
+package svgsequence
+
+// SetSectionRange sets the steps of the i-th section, from first to last,
+// to build ranges that cannot be created by opening and closing sections
+func SetSectionRange(s *Sequence, i, first, last int) {
+	s.sections[i].firstStepIndex = &first
+	s.sections[i].lastStepIndex = &last
+}
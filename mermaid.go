@@ -0,0 +1,170 @@
+// SPDX-License-Identifier: MIT
+
+package svgsequence
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// mermaidArrows are the message arrows of Mermaid and their style, the longest first so they are matched before their prefixes.
+// The dotted arrows are drawn dashed and the open arrows as async, the cross arrows have no style and are not supported
+var mermaidArrows = []struct {
+	arrow       string
+	style       ArrowStyle
+	unsupported bool
+}{
+	{"-->>", Dashed, false}, {"->>", Solid, false}, {"--x", Dashed, true}, {"-x", Solid, true},
+	{"--)", Async, false}, {"-)", Async, false}, {"-->", Dashed, false}, {"->", Solid, false},
+}
+
+// GenerateFromMermaid generates the sequence by parsing a Mermaid sequenceDiagram
+func GenerateFromMermaid(r io.Reader) (string, error) {
+	s, err := ParseMermaid(r)
+	if err != nil {
+		return "", err
+	}
+	return s.Generate()
+}
+
+// ParseMermaid parses a subset of the Mermaid sequenceDiagram syntax into a sequence:
+// participants and actors (with aliases), messages, notes, title, autonumber and the
+// loop, alt, opt, par and critical blocks, which are drawn as sections.
+//
+// The dotted message arrows are drawn dashed, the open arrows ("-)" and "--)") as async
+// and the other ones as solid arrows, the activations are ignored.
+// The cross arrows ("-x" and "--x") return an error.
+func ParseMermaid(r io.Reader) (*Sequence, error) {
+	scanner := bufio.NewScanner(r)
+	s := NewSequence()
+	names := make(map[string]string) // map[alias]name
+	name := func(id string) string {
+		if n, ok := names[id]; ok {
+			return n
+		}
+		return id
+	}
+
+	header := false
+	lineNum := 0
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		lineNum++
+
+		// Skip empty lines and comments
+		if line == "" || strings.HasPrefix(line, "%%") {
+			continue
+		}
+		if !header {
+			if line != "sequenceDiagram" {
+				return nil, &ParseError{Line: lineNum, Message: "expected sequenceDiagram"}
+			}
+			header = true
+			continue
+		}
+
+		keyword, rest, _ := strings.Cut(line, " ")
+		rest = strings.TrimSpace(rest)
+		switch strings.ToLower(keyword) {
+		case "participant", "actor":
+			id, alias, ok := strings.Cut(rest, " as ")
+			id = strings.TrimSpace(id)
+			if ok {
+				names[id] = strings.TrimSpace(alias)
+			}
+			if strings.EqualFold(keyword, "actor") {
+				s.AddHumanActor(name(id))
+			} else {
+				s.AppendActors(name(id))
+			}
+
+		case "note":
+			placement, text, ok := strings.Cut(rest, ":")
+			if !ok {
+				return nil, &ParseError{Line: lineNum, Message: "note without text"}
+			}
+			var actors []string
+			placement = strings.TrimSpace(placement)
+			for _, prefix := range []string{"over ", "left of ", "right of "} {
+				if ids, ok := strings.CutPrefix(placement, prefix); ok {
+					for _, id := range strings.Split(ids, ",") {
+						actors = append(actors, name(strings.TrimSpace(id)))
+					}
+				}
+			}
+			if len(actors) == 0 {
+				return nil, &ParseError{Line: lineNum, Message: "note without actors"}
+			}
+			s.AddNote(mermaidText(text), actors...)
+
+		case "loop", "alt", "opt", "par", "critical":
+			s.OpenSection(strings.TrimSpace(keyword+" "+rest), nil)
+
+		case "else", "and", "option":
+			s.CloseSection()
+			s.OpenSection(strings.TrimSpace(keyword+" "+rest), nil)
+
+		case "end":
+			s.CloseSection()
+
+		case "autonumber":
+			s.SetAutonumber(true)
+
+		case "title":
+			s.SetTitle(rest)
+
+		case "activate", "deactivate":
+			// not supported, ignored
+
+		default:
+			src, tgt, text, style, err := parseMermaidMessage(line)
+			if err != nil {
+				return nil, &ParseError{Line: lineNum, Message: err.Error()}
+			}
+			s.AddStep(Step{Source: name(src), Target: name(tgt), Text: text, Style: style})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !header {
+		return nil, fmt.Errorf("expected sequenceDiagram")
+	}
+
+	return s, nil
+}
+
+// parseMermaidMessage parses a message like "A->>B: text",
+// returning an error if the line is not a message or its arrow is not supported
+func parseMermaidMessage(line string) (src, tgt, text string, style ArrowStyle, err error) {
+	unknown := fmt.Errorf(`unknown statement: "%s"`, line)
+	actors, text, ok := strings.Cut(line, ":")
+	if !ok {
+		return "", "", "", Solid, unknown
+	}
+	for _, a := range mermaidArrows {
+		src, tgt, found := strings.Cut(actors, a.arrow)
+		if !found {
+			continue
+		}
+		// the activation shorthands are ignored
+		src = strings.TrimSpace(src)
+		tgt = strings.TrimLeft(strings.TrimSpace(tgt), "+-")
+		if src == "" || tgt == "" {
+			return "", "", "", Solid, unknown
+		}
+		if a.unsupported {
+			return "", "", "", Solid, fmt.Errorf(`unsupported arrow: "%s"`, a.arrow)
+		}
+		return src, tgt, mermaidText(text), a.style, nil
+	}
+	return "", "", "", Solid, unknown
+}
+
+// mermaidText returns the text with the <br> line breaks replaced by new lines
+func mermaidText(text string) string {
+	r := strings.NewReplacer("<br>", "\n", "<br/>", "\n", "<br />", "\n")
+	return r.Replace(strings.TrimSpace(text))
+}
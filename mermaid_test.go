@@ -0,0 +1,146 @@
+// SPDX-License-Identifier: MIT
+
+package svgsequence_test
+
+import (
+	"strings"
+	"testing"
+
+	svgsequence "github.com/aorith/svg-sequence"
+)
+
+func TestParseMermaid(t *testing.T) {
+	src := `%% login flow
+sequenceDiagram
+    actor U as User
+    participant W as Web App
+    participant DB
+    U->>+W: login
+    loop every retry
+        W-->>DB: find user<br>by email
+    end
+    alt found
+        DB-->>W: user
+    else not found
+        DB-)W: nothing
+    end
+    Note over U,W: session created
+    Note right of DB: cached
+    W->>-U: token
+`
+	s, err := svgsequence.ParseMermaid(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `actors:
+  "User"
+  "Web App"
+  "DB"
+steps:
+  1. "User" -> "Web App" "login" #000000
+  2. "Web App" -> "DB" "find user\nby email" #000000
+  3. "DB" -> "Web App" "user" #000000
+  4. "DB" -> "Web App" "nothing" #000000
+  5. note "session created" over ["User" "Web App"] #000000
+  6. note "cached" over ["DB"] #000000
+  7. "Web App" -> "User" "token" #000000
+sections:
+  "loop every retry" #000000 steps 2-2
+  "alt found" #000000 steps 3-3
+  "else not found" #000000 steps 4-4
+`
+	if got := s.Canonical(); got != want {
+		t.Errorf("ParseMermaid() =\n%s\nwant:\n%s", got, want)
+	}
+
+	if _, err := svgsequence.GenerateFromMermaid(strings.NewReader(src)); err != nil {
+		t.Errorf("GenerateFromMermaid() returned an error: %v", err)
+	}
+}
+
+func TestParseMermaidErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"graph TD\nA-->B", "expected sequenceDiagram at line 1"},
+		{"sequenceDiagram\nA->>B", `unknown statement: "A->>B" at line 2`},
+		{"sequenceDiagram\nNote over A", "note without text at line 2"},
+		{"sequenceDiagram\nNote above A: text", "note without actors at line 2"},
+		{"sequenceDiagram\nA-xB: lost", `unsupported arrow: "-x" at line 2`},
+		{"sequenceDiagram\nA--xB: lost", `unsupported arrow: "--x" at line 2`},
+	}
+	for _, tt := range tests {
+		_, err := svgsequence.ParseMermaid(strings.NewReader(tt.src))
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("ParseMermaid(%q) error = %v, want %s", tt.src, err, tt.want)
+		}
+	}
+}
+
+func TestParseMermaidAutonumber(t *testing.T) {
+	got, err := svgsequence.GenerateFromMermaid(strings.NewReader("sequenceDiagram\nautonumber\nA->>B: hello\nB-->>A: world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`>1 hello</text>`, `>2 world</text>`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("GenerateFromMermaid() output does not contain %s", want)
+		}
+	}
+}
+
+func TestParseMermaidTitle(t *testing.T) {
+	got, err := svgsequence.GenerateFromMermaid(strings.NewReader("sequenceDiagram\ntitle Login flow\nA->>B: hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `<title>Login flow</title>`; !strings.Contains(got, want) {
+		t.Errorf("GenerateFromMermaid() output does not contain %s", want)
+	}
+}
+
+func TestParseMermaidArrowStyles(t *testing.T) {
+	src := `sequenceDiagram
+    A->>B: call
+    B-->>A: reply
+    A-)B: notify
+    B--)A: notified
+    A-->B: dotted
+`
+	s, err := svgsequence.ParseMermaid(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the styles are kept when the sequence is written back
+	var sb strings.Builder
+	if err := s.WritePlantUML(&sb); err != nil {
+		t.Fatal(err)
+	}
+	want := `@startuml
+participant "A"
+participant "B"
+"A" -> "B" : call
+"B" --> "A" : reply
+"A" -->> "B" : notify
+"B" -->> "A" : notified
+"A" --> "B" : dotted
+@enduml
+`
+	if sb.String() != want {
+		t.Errorf("ParseMermaid() written as PlantUML =\n%s\nwant:\n%s", sb.String(), want)
+	}
+
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(got, `marker-end="url(#seq-arrow-open)"`); n != 2 {
+		t.Errorf("ParseMermaid() output has %d async arrows, want 2", n)
+	}
+	if n := strings.Count(got, `marker-end="url(#seq-arrow)"`); n != 3 {
+		t.Errorf("ParseMermaid() output has %d filled arrows, want 3", n)
+	}
+}
@@ -5,17 +5,38 @@ package svgsequence
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
 )
 
+// ParseError is returned when a line of a config or Mermaid file cannot be parsed
+type ParseError struct {
+	Line    int
+	Message string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s at line %d", e.Message, e.Line)
+}
+
 // GenerateFromCFG generates the sequence by parsing a config file
 func GenerateFromCFG(filename string) (string, error) {
+	s, err := ParseCFG(filename)
+	if err != nil {
+		return "", err
+	}
+	return s.Generate()
+}
+
+// ParseCFG parses a config file into a sequence
+func ParseCFG(filename string) (*Sequence, error) {
 	data, err := os.ReadFile(filename)
 	if err != nil {
-		return "", fmt.Errorf("error reading file '%s': %v", filename, err)
+		return nil, fmt.Errorf("error reading file '%s': %v", filename, err)
 	}
 
 	r := bytes.NewReader(data)
@@ -34,41 +55,46 @@ func GenerateFromCFG(filename string) (string, error) {
 			continue
 		}
 
-		// Sequence properties
-		key, val, ok := strings.Cut(line, "=")
-		key, val = strings.TrimSpace(key), strings.TrimSpace(val)
-		if ok {
-			switch key {
-			case "distance_between_actors":
-				s.SetDistance(parseIntDefault(val, defaultDistance))
-			case "step_height":
-				s.SetStepHeight(parseIntDefault(val, defaultStepHeight))
-			case "width":
-				s.SetWidth(val)
-			case "height":
-				s.SetHeight(val)
-			case "vertical_section_text":
-				s.SetVerticalSectionText(val == "1" || val == "true" || val == "True")
-			}
+		// A leading '\#' or '\@' is literal, so the line is neither a comment nor a directive
+		escaped := isEscaped(line)
+		if escaped {
+			line = line[1:]
 		}
 
 		parts := strings.Split(line, " ")
 		property := parts[0]
-		if property[0] != '@' {
+
+		// Compact steps and sequence properties, only considered for lines which are not directives
+		if escaped || property[0] != '@' {
+			if step, ok := parseArrowStep(line); ok {
+				s.AddStep(step)
+				continue
+			}
+			key, val, ok := strings.Cut(line, "=")
+			if ok {
+				setOption(s, strings.TrimSpace(key), strings.TrimSpace(val))
+			}
 			continue
 		}
 
 		switch property {
 		case "@actors":
-			s.AddActors(parseProperty(line, property)...)
+			values, err := parseProperty(line, property)
+			if err != nil {
+				return nil, &ParseError{Line: lineNum, Message: err.Error()}
+			}
+			s.AddActors(values...)
 
 		case "@start":
-			values := parseProperty(line, property)
+			values, err := parseProperty(line, property)
+			if err != nil {
+				return nil, &ParseError{Line: lineNum, Message: err.Error()}
+			}
 			var name, color string
 			bordered := true
 			switch len(values) {
 			case 0:
-				return "", fmt.Errorf("section needs a name at line %d", lineNum)
+				return nil, &ParseError{Line: lineNum, Message: "section needs a name"}
 			case 1:
 				name = values[0]
 			case 2:
@@ -83,18 +109,54 @@ func GenerateFromCFG(filename string) (string, error) {
 			}
 			s.OpenSection(name, &SectionConfig{Color: color, WithoutBorder: !bordered})
 
+		case "@set":
+			key, val, _ := strings.Cut(strings.TrimSpace(strings.TrimPrefix(line, property)), " ")
+			val = strings.TrimSpace(val)
+			// "@set meta <name> <value>", the name is the first word after meta
+			if key == "meta" {
+				name, metaVal, _ := strings.Cut(val, " ")
+				key, val = key+" "+name, strings.TrimSpace(metaVal)
+			}
+			if !setOption(s, key, val) {
+				return nil, &ParseError{Line: lineNum, Message: fmt.Sprintf(`unknown option: "%s"`, key)}
+			}
+
 		case "@end":
 			s.CloseSection()
 
+		case "@activate", "@deactivate":
+			values, err := parseProperty(line, property)
+			if err != nil {
+				return nil, &ParseError{Line: lineNum, Message: err.Error()}
+			}
+			for _, name := range values {
+				if property == "@activate" {
+					s.Activate(name)
+				} else {
+					s.Deactivate(name)
+				}
+			}
+
 		case "@closeall":
 			s.CloseAllSections()
 
 		case "@step":
-			values := parseProperty(line, property)
+			values, err := parseProperty(line, property)
+			if err != nil {
+				return nil, &ParseError{Line: lineNum, Message: err.Error()}
+			}
 			var src, tgt, desc, color string
+			var styles []string
+			if len(values) > 3 {
+				// the color is optional before the style tokens
+				styles = values[3:]
+				if _, ok := stepStyles[values[3]]; !ok {
+					styles = values[4:]
+				}
+			}
 			switch len(values) {
 			case 0, 1:
-				return "", fmt.Errorf("not enough values for step at line %d", lineNum)
+				return nil, &ParseError{Line: lineNum, Message: "not enough values for step"}
 			case 2:
 				src = values[0]
 				tgt = values[1]
@@ -106,21 +168,95 @@ func GenerateFromCFG(filename string) (string, error) {
 				src = values[0]
 				tgt = values[1]
 				desc = values[2]
-				color = values[3]
+				if len(styles) < len(values)-3 {
+					color = values[3]
+				}
 			}
-			s.AddStep(Step{
+			step := Step{
 				Text:   desc,
 				Source: src,
 				Target: tgt,
 				Color:  color,
-			})
+			}
+			for _, style := range styles {
+				apply, ok := stepStyles[style]
+				if !ok {
+					return nil, &ParseError{Line: lineNum, Message: fmt.Sprintf(`unknown step style: "%s"`, style)}
+				}
+				apply(&step)
+			}
+			s.AddStep(step)
 
 		default:
-			return "", fmt.Errorf(`unknown property: "%s" at line %d`, property, lineNum)
+			return nil, &ParseError{Line: lineNum, Message: fmt.Sprintf(`unknown property: "%s"`, property)}
 		}
 	}
 
-	return s.Generate()
+	return s, nil
+}
+
+// arrowStepRe matches the compact steps: "source -> target #color: description"
+// or "source -> target: description #color", the arrow is "-->" for a dashed line
+// and the description and color are optional. A description ending with a word
+// like "#123" must escape it as "\#123" or put the color before the colon.
+var arrowStepRe = regexp.MustCompile(`^([^=:]+?)\s*(-->|->)\s*([^:]+?)(?:\s+(#[0-9a-fA-F]{6}|#[0-9a-fA-F]{3})(?:\s*:\s*(.*))?|(?:\s*:\s*(.*?))?(?:\s+(#[0-9a-fA-F]{6}|#[0-9a-fA-F]{3}))?)$`)
+
+// parseArrowStep parses a compact step, returns false if the line is not one
+func parseArrowStep(line string) (Step, bool) {
+	m := arrowStepRe.FindStringSubmatch(line)
+	if m == nil {
+		return Step{}, false
+	}
+	// the color and the description are captured before or after the colon
+	color, text := m[4], m[5]
+	if color == "" {
+		color, text = m[7], m[6]
+	}
+	step := Step{
+		Source: m[1],
+		Target: m[3],
+		Text:   strings.NewReplacer(`\n`, "\n", `\#`, "#").Replace(text),
+		Color:  color,
+	}
+	if m[2] == "-->" {
+		stepStyles["dashed"](&step)
+	}
+	return step, true
+}
+
+// stepStyles are the style tokens accepted after the color of a step
+var stepStyles = map[string]func(*Step){
+	"dashed":  func(st *Step) { st.Style = Dashed },
+	"async":   func(st *Step) { st.Style = Async },
+	"noarrow": func(st *Step) { st.NoArrow = true },
+}
+
+// setOption sets a sequence option from its config key and value,
+// returns false if the key is unknown
+func setOption(s *Sequence, key, val string) bool {
+	switch key {
+	case "distance_between_actors", "distance":
+		s.SetDistance(parseIntDefault(val, defaultDistance))
+	case "step_height":
+		s.SetStepHeight(parseIntDefault(val, defaultStepHeight))
+	case "width":
+		s.SetWidth(val)
+	case "height":
+		s.SetHeight(val)
+	case "vertical_section_text":
+		s.SetVerticalSectionText(val == "1" || val == "true" || val == "True")
+	case "self_style":
+		s.SetSelfStyle(val)
+	case "self_label_offset":
+		s.SetSelfLabelOffset(parseIntDefault(val, defaultSelfLabelOffset))
+	default:
+		name, found := strings.CutPrefix(key, "meta ")
+		if !found {
+			return false
+		}
+		s.SetMeta(strings.TrimSpace(name), val)
+	}
+	return true
 }
 
 // parseIntDefault is a helper function to convert a string to int
@@ -133,19 +269,71 @@ func parseIntDefault(s string, def int) int {
 	return n
 }
 
-// parseProperty is a helper function to separate values from properties
-func parseProperty(line, property string) []string {
+// parseProperty is a helper function to separate values from properties,
+// the values between double quotes can contain commas and escaped quotes
+func parseProperty(line, property string) ([]string, error) {
 	// remove the prefix (@actors, @start, ...)
 	rest := strings.TrimPrefix(line, property)
 
-	parts := strings.Split(rest, ",")
-	values := make([]string, 0, len(parts))
-	for _, p := range parts {
+	var values []string
+	for {
+		rest = strings.TrimLeft(rest, " \t")
+		if strings.HasPrefix(rest, `"`) {
+			value, after, err := unquote(rest)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+			after = strings.TrimLeft(after, " \t")
+			if after == "" {
+				break
+			}
+			if after[0] != ',' {
+				return nil, errors.New("unexpected text after a quoted value")
+			}
+			rest = after[1:]
+			continue
+		}
+
+		p, after, found := strings.Cut(rest, ",")
 		trimmed := strings.TrimSpace(p)
 		trimmed = strings.ReplaceAll(trimmed, `\n`, "\n")
+		if isEscaped(trimmed) {
+			trimmed = trimmed[1:]
+		}
 		if trimmed != "" {
 			values = append(values, trimmed)
 		}
+		if !found {
+			break
+		}
+		rest = after
 	}
-	return values
+	return values, nil
+}
+
+// unquote returns the value of the double-quoted string at the start of s
+// and the text after its closing quote, expanding the escaped quotes and newlines
+func unquote(s string) (value, rest string, err error) {
+	var sb strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch {
+		case s[i] == '"':
+			return sb.String(), s[i+1:], nil
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == '"':
+			sb.WriteByte('"')
+			i++
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == 'n':
+			sb.WriteByte('\n')
+			i++
+		default:
+			sb.WriteByte(s[i])
+		}
+	}
+	return "", "", errors.New("unterminated quote")
+}
+
+// isEscaped returns true if the string starts with an escaped '#' or '@'
+func isEscaped(s string) bool {
+	return strings.HasPrefix(s, `\#`) || strings.HasPrefix(s, `\@`)
 }
@@ -0,0 +1,280 @@
+// SPDX-License-Identifier: MIT
+
+package svgsequence_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	svgsequence "github.com/aorith/svg-sequence"
+)
+
+// generateFromString writes the config to a temporary file and generates the sequence from it
+func generateFromString(t *testing.T, cfg string) (string, error) {
+	t.Helper()
+	fn := filepath.Join(t.TempDir(), "sequence.cfg")
+	if err := os.WriteFile(fn, []byte(cfg), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return svgsequence.GenerateFromCFG(fn)
+}
+
+func TestParseMeta(t *testing.T) {
+	got, err := generateFromString(t, `
+meta author = Jane
+meta Some-Custom Key = any value = with equals
+@set meta keywords login, auth flow
+@step A, B, hello
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`<meta name="Some-Custom Key">any value = with equals</meta>`,
+		`<meta name="author">Jane</meta>`,
+		`<meta name="keywords">login, auth flow</meta>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("meta output does not contain %s", want)
+		}
+	}
+}
+
+func TestParseEscapes(t *testing.T) {
+	got, err := generateFromString(t, `
+# a comment
+\# not a comment, but ignored
+\@unknown is not a directive
+@actors \@foo, B
+@step \@foo, B, \#hashtag
+@step B, \@foo, #1 unescaped
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`text-anchor="middle">@foo</text>`,
+		`text-anchor="middle">#hashtag</text>`,
+		`text-anchor="middle">#1 unescaped</text>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("escaped output does not contain %s", want)
+		}
+	}
+	if strings.Contains(got, `\`) {
+		t.Errorf("escaped output contains a backslash")
+	}
+}
+
+func TestParseSetOption(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  string
+	}{
+		{"legacy", "distance_between_actors = 300\n@step A, B, x=y"},
+		{"set", "@step A, B, x=y\n@set distance 300"},
+		{"set legacy key", "@set distance_between_actors 300\n@step A, B, x=y"},
+	}
+	for _, tt := range tests {
+		got, err := generateFromString(t, tt.cfg)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		for _, want := range []string{`viewBox="0 0 640 96"`, `text-anchor="middle">x=y</text>`} {
+			if !strings.Contains(got, want) {
+				t.Errorf("%s: output does not contain %s", tt.name, want)
+			}
+		}
+	}
+
+	got, err := generateFromString(t, "self_label_offset = 20\n@step A, A, validate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `x="130" y="61" fill="#000000" stroke="none" font-size="10" text-anchor="start">validate</text>`; !strings.Contains(got, want) {
+		t.Errorf("self_label_offset: output does not contain %s", want)
+	}
+
+	if _, err := generateFromString(t, "@set unknown 1\n@step A, B"); err == nil {
+		t.Errorf("@set with an unknown option should return an error")
+	}
+}
+
+func TestParseDirectiveWithEquals(t *testing.T) {
+	got, err := generateFromString(t, "@step A, B, width = 900\n@step B, A, step_height=10")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`width="100%"`,
+		`viewBox="0 0 400 144"`,
+		`text-anchor="middle">width = 900</text>`,
+		`text-anchor="middle">step_height=10</text>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output does not contain %s", want)
+		}
+	}
+}
+
+func TestParseStepStyles(t *testing.T) {
+	tests := []struct {
+		name string
+		step string
+		want string
+	}{
+		{"color", "@step A, B, hi, red", `<line x1="110" y1="68" x2="285" y2="68" fill="red" stroke="red" stroke-width="2" marker-start`},
+		{"dashed", "@step A, B, hi, red, dashed", `<line x1="110" y1="68" x2="285" y2="68" fill="red" stroke="red" stroke-width="2" stroke-dasharray="4 4" marker-start`},
+		{"without color", "@step A, B, hi, dashed", `<line x1="110" y1="68" x2="285" y2="68" fill="#000000" stroke="#000000" stroke-width="2" stroke-dasharray="4 4" marker-start`},
+		{"async", "@step A, B, hi, red, async", `<line x1="110" y1="68" x2="285" y2="68" fill="red" stroke="red" stroke-width="2" stroke-dasharray="4 4" marker-start="url(#seq-dot)" marker-end="url(#seq-arrow-open)"></line>`},
+		{"noarrow", "@step A, B, hi, red, noarrow", `<line x1="110" y1="68" x2="290" y2="68" fill="red" stroke="red" stroke-width="2"></line>`},
+		{"combined", "@step A, B, hi, blue, noarrow, dashed", `<line x1="110" y1="68" x2="290" y2="68" fill="blue" stroke="blue" stroke-width="2" stroke-dasharray="4 4"></line>`},
+	}
+	for _, tt := range tests {
+		got, err := generateFromString(t, tt.step)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !strings.Contains(got, tt.want) {
+			t.Errorf("%s: output does not contain %s", tt.name, tt.want)
+		}
+	}
+
+	_, err := generateFromString(t, "@step A, B, hi\n@step A, B, hi, red, sparkly")
+	if err == nil || err.Error() != `unknown step style: "sparkly" at line 2` {
+		t.Errorf("unknown style error = %v", err)
+	}
+}
+
+func TestParseError(t *testing.T) {
+	_, err := generateFromString(t, "@step A, B, hi\n\n@foo bar")
+	var perr *svgsequence.ParseError
+	if !errors.As(err, &perr) {
+		t.Fatalf("error = %v, want a *ParseError", err)
+	}
+	if perr.Line != 3 || perr.Message != `unknown property: "@foo"` {
+		t.Errorf("ParseError = %+v", perr)
+	}
+}
+
+func TestCompactSteps(t *testing.T) {
+	tests := []struct {
+		name string
+		step string
+		want []string
+	}{
+		{"solid", "A -> B: hello", []string{
+			`<line x1="110" y1="68" x2="285" y2="68" fill="#000000" stroke="#000000" stroke-width="2" marker-start`,
+			`text-anchor="middle">hello</text>`,
+		}},
+		{"dashed", "A --> B: hello", []string{
+			`<line x1="110" y1="68" x2="285" y2="68" fill="#000000" stroke="#000000" stroke-width="2" stroke-dasharray="4 4" marker-start`,
+		}},
+		{"self", "A -> A: myself", []string{
+			`<circle cx="110" cy="68" r="3" fill="#000000"></circle>`,
+			`text-anchor="start">myself</text>`,
+		}},
+		{"color", "A -> B: hello #AA0000", []string{
+			`<line x1="110" y1="68" x2="285" y2="68" fill="#AA0000" stroke="#AA0000" stroke-width="2" marker-start`,
+			`text-anchor="middle">hello</text>`,
+		}},
+		{"color before description", "A -> B #AA0000: fix bug #123", []string{
+			`<line x1="110" y1="68" x2="285" y2="68" fill="#AA0000" stroke="#AA0000" stroke-width="2" marker-start`,
+			`text-anchor="middle">fix bug #123</text>`,
+		}},
+		{"escaped hash", `A -> B: fix bug \#123`, []string{
+			`<line x1="110" y1="68" x2="285" y2="68" fill="#000000" stroke="#000000" stroke-width="2" marker-start`,
+			`text-anchor="middle">fix bug #123</text>`,
+		}},
+		{"color without description", "A-->B #a00", []string{
+			`<line x1="110" y1="68" x2="285" y2="68" fill="#a00" stroke="#a00" stroke-width="2" stroke-dasharray="4 4" marker-start`,
+		}},
+	}
+	for _, tt := range tests {
+		got, err := generateFromString(t, tt.step)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("%s: output does not contain %s", tt.name, want)
+			}
+		}
+	}
+
+	// both forms can be mixed and the options are still parsed
+	got, err := generateFromString(t, "step_height = 60\n@step A, B, first\nB -> A: second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got, `<line x1="290" y1="138" x2="115" y2="138"`) {
+		t.Errorf("mixed steps output does not contain the compact step")
+	}
+}
+
+func TestQuotedValues(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  string
+		want []string
+	}{
+		{"comma", `@step A, B, "wait 30s, then retry", #ff0000`, []string{
+			`stroke="#ff0000"`,
+			`text-anchor="middle">wait 30s, then retry</text>`,
+		}},
+		{"escaped quote and newline", `@step A, B, "say \"hi\",\nthen wait"`, []string{
+			`text-anchor="middle">say &#34;hi&#34;,</text>`,
+			`text-anchor="middle">then wait</text>`,
+		}},
+		{"directive characters", `@step A, B, "@user, #1"`, []string{
+			`text-anchor="middle">@user, #1</text>`,
+		}},
+		{"actors", `@actors "Web, App", DB` + "\n@step DB, \"Web, App\"", []string{
+			`text-anchor="middle">Web, App</text>`,
+		}},
+		{"unquoted", `@step A, B, say "hi", #ff0000`, []string{
+			`stroke="#ff0000"`,
+			`text-anchor="middle">say &#34;hi&#34;</text>`,
+		}},
+	}
+	for _, tt := range tests {
+		got, err := generateFromString(t, tt.cfg)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("%s: output does not contain %s", tt.name, want)
+			}
+		}
+	}
+
+	for _, cfg := range []string{`@step A, B, "unterminated, #ff0000`, `@step A, B, "quoted" text`} {
+		_, err := generateFromString(t, "@step A, B\n"+cfg)
+		var perr *svgsequence.ParseError
+		if !errors.As(err, &perr) || perr.Line != 2 {
+			t.Errorf("%s: error = %v, want a parse error at line 2", cfg, err)
+		}
+	}
+}
+
+func TestActivateDirectives(t *testing.T) {
+	got, err := generateFromString(t, "@actors A, B\n@activate B\n@step A, B, call\n@step B, A, reply\n@deactivate B")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `<rect class="seq-activation" x="285" y="63" width="10" height="60"`; !strings.Contains(got, want) {
+		t.Errorf("@activate output does not contain %s", want)
+	}
+
+	if _, err := generateFromString(t, "@step A, B, call\n@deactivate B"); err == nil {
+		t.Errorf("@deactivate without @activate returned no error")
+	}
+}
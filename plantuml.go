@@ -0,0 +1,140 @@
+// SPDX-License-Identifier: MIT
+
+package svgsequence
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WritePlantUML writes the sequence to w as a PlantUML sequence diagram.
+//
+// The actors are written as participants, the steps as messages with their color and style,
+// the notes and reference fragments over their actors, the sections as groups and
+// the activations of the actors with activate and deactivate.
+// The decisions are written as notes at the right of their actor, the time breaks
+// as delays of the whole diagram, and the options that PlantUML does not support,
+// like the durations, are not written.
+// A note without actors in a sequence without actors is written across the diagram,
+// and a reference fragment in such a sequence returns an error.
+func (s *Sequence) WritePlantUML(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "@startuml")
+
+	for _, name := range s.actors {
+		kind := "participant"
+		switch s.actorsMap[name].style {
+		case actorDatabase:
+			kind = "database"
+		case actorHuman:
+			kind = "actor"
+		case actorQueue:
+			kind = "queue"
+		}
+		fmt.Fprintf(bw, "%s %s\n", kind, plantUMLName(name))
+	}
+
+	for i, st := range s.steps {
+		for _, sec := range s.sections {
+			if sec.firstStepIndex != nil && *sec.firstStepIndex == i {
+				fmt.Fprintf(bw, "group %s\n", sec.name)
+			}
+		}
+
+		text := plantUMLText(st.Text)
+		switch {
+		case st.ref:
+			span := s.noteSpan(st)
+			if len(span) == 0 {
+				return fmt.Errorf("step #%d: reference fragment spans no actors", i+1)
+			}
+			fmt.Fprintf(bw, "ref over %s : %s\n", plantUMLNames(span), text)
+		case st.note:
+			// a note without actors spans the whole diagram
+			if span := s.noteSpan(st); len(span) > 0 {
+				fmt.Fprintf(bw, "note over %s : %s\n", plantUMLNames(span), text)
+			} else {
+				fmt.Fprintf(bw, "note across : %s\n", text)
+			}
+		case st.decision:
+			fmt.Fprintf(bw, "note right of %s : %s\n", plantUMLName(st.Source), text)
+		case st.timeBreak:
+			fmt.Fprintln(bw, "...")
+		default:
+			if st.CreatesTarget {
+				fmt.Fprintf(bw, "create %s\n", plantUMLName(st.Target))
+			}
+			head := ">"
+			switch s.arrowStyle(st) {
+			case Dashed:
+				head = "->"
+			case Async:
+				head = "->>"
+			}
+			arrow := "-" + head
+			if !st.autoColor {
+				arrow = fmt.Sprintf("-[%s]%s", st.Color, head)
+			}
+			fmt.Fprintf(bw, "%s %s %s", plantUMLName(st.Source), arrow, plantUMLName(st.Target))
+			if text != "" {
+				fmt.Fprintf(bw, " : %s", text)
+			}
+			fmt.Fprintln(bw)
+		}
+
+		for _, a := range s.activations {
+			if a.first == i && a.last >= i {
+				fmt.Fprintf(bw, "activate %s\n", plantUMLName(a.actor))
+			}
+		}
+		for j := len(s.activations) - 1; j >= 0; j-- {
+			if a := s.activations[j]; a.last == i && a.first <= i {
+				fmt.Fprintf(bw, "deactivate %s\n", plantUMLName(a.actor))
+			}
+		}
+
+		for j := len(s.sections) - 1; j >= 0; j-- {
+			sec := s.sections[j]
+			if sec.firstStepIndex != nil && sec.lastStepIndex != nil && *sec.lastStepIndex == i {
+				fmt.Fprintln(bw, "end")
+			}
+		}
+	}
+
+	fmt.Fprintln(bw, "@enduml")
+	return bw.Flush()
+}
+
+// noteSpan returns the leftmost and rightmost actors of a note,
+// a single actor if they are the same, or none if the sequence has no actors
+func (s *Sequence) noteSpan(st *Step) []string {
+	source, target, err := s.resolveNote(st)
+	if err != nil {
+		return nil
+	}
+	if source == target {
+		return []string{source}
+	}
+	return []string{source, target}
+}
+
+// plantUMLName returns the quoted name of an actor
+func plantUMLName(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `'`) + `"`
+}
+
+// plantUMLNames returns the quoted names of the actors separated by commas
+func plantUMLNames(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = plantUMLName(name)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// plantUMLText returns the text with the line breaks escaped
+func plantUMLText(text string) string {
+	return strings.ReplaceAll(text, "\n", `\n`)
+}
@@ -0,0 +1,123 @@
+// SPDX-License-Identifier: MIT
+
+package svgsequence_test
+
+import (
+	"strings"
+	"testing"
+
+	svgsequence "github.com/aorith/svg-sequence"
+)
+
+func TestWritePlantUML(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.AddHumanActor("User")
+	s.AddActors("User", "Web App")
+	s.AddDatabaseActor("DB")
+	s.OpenSection("Login", nil)
+	s.AddStep(svgsequence.Step{Source: "User", Target: "Web App", Text: "login"})
+	s.AddStep(svgsequence.Step{Source: "Web App", Target: "DB", Text: "find user\nby email", Color: "#FF0000"})
+	s.CloseSection()
+	s.AddStep(svgsequence.Step{Source: "Web App", Target: "Web App", Text: "hash password"})
+	s.AddDecision("Web App", "valid?")
+	s.AddNote("session created", "Web App", "User")
+	s.AddRef("see Logout")
+	s.AddStep(svgsequence.Step{Source: "Web App", Target: "Audit", Text: "log", CreatesTarget: true})
+
+	var sb strings.Builder
+	if err := s.WritePlantUML(&sb); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `@startuml
+actor "User"
+participant "Web App"
+database "DB"
+participant "Audit"
+group Login
+"User" -> "Web App" : login
+"Web App" -[#FF0000]> "DB" : find user\nby email
+end
+"Web App" -> "Web App" : hash password
+note right of "Web App" : valid?
+note over "User", "Web App" : session created
+ref over "User", "Audit" : see Logout
+create "Audit"
+"Web App" -> "Audit" : log
+@enduml
+`
+	if got := sb.String(); got != want {
+		t.Errorf("WritePlantUML() =\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestWritePlantUMLActivations(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.AddActors("A", "B")
+	s.Activate("B")
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "call"})
+	s.AddStep(svgsequence.Step{Source: "B", Target: "A", Text: "reply"})
+	s.Deactivate("B")
+
+	var sb strings.Builder
+	if err := s.WritePlantUML(&sb); err != nil {
+		t.Fatal(err)
+	}
+	want := `@startuml
+participant "A"
+participant "B"
+"A" -> "B" : call
+activate "B"
+"B" -> "A" : reply
+deactivate "B"
+@enduml
+`
+	if sb.String() != want {
+		t.Errorf("WritePlantUML() =\n%s\nwant:\n%s", sb.String(), want)
+	}
+}
+
+func TestWritePlantUMLArrowStyles(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.RegisterMessageType("event", "", svgsequence.Async, "Event")
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "call"})
+	s.AddStep(svgsequence.Step{Source: "B", Target: "A", Text: "reply", Style: svgsequence.Dashed})
+	s.AddStep(svgsequence.Step{Source: "B", Target: "A", Text: "error", Style: svgsequence.Dashed, Color: "#FF0000"})
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "notify", Style: svgsequence.Async})
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "published", Type: "event"})
+
+	var sb strings.Builder
+	if err := s.WritePlantUML(&sb); err != nil {
+		t.Fatal(err)
+	}
+	want := `@startuml
+participant "A"
+participant "B"
+"A" -> "B" : call
+"B" --> "A" : reply
+"B" -[#FF0000]-> "A" : error
+"A" -->> "B" : notify
+"A" -->> "B" : published
+@enduml
+`
+	if sb.String() != want {
+		t.Errorf("WritePlantUML() =\n%s\nwant:\n%s", sb.String(), want)
+	}
+}
+
+func TestWritePlantUMLNoteWithoutActors(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.AddNote("x")
+
+	var sb strings.Builder
+	if err := s.WritePlantUML(&sb); err != nil {
+		t.Fatal(err)
+	}
+	want := `@startuml
+note across : x
+@enduml
+`
+	if sb.String() != want {
+		t.Errorf("WritePlantUML() =\n%s\nwant:\n%s", sb.String(), want)
+	}
+}
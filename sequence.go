@@ -3,36 +3,154 @@
 package svgsequence
 
 import (
+	"cmp"
+	"context"
+	"crypto/sha256"
 	_ "embed"
+	"encoding/hex"
 	"encoding/xml"
 	"fmt"
+	"html"
+	"io"
+	"maps"
 	"math"
+	"math/rand/v2"
 	"slices"
 	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 //go:embed default.css
 var defaultCSS string
 
+// DefaultColor is the color used when no other color is configured.
+const DefaultColor = "#000000"
+
+// actorPalette holds the colors assigned to the actors when coloring by actor
+var actorPalette = []string{
+	"#1F77B4", "#FF7F0E", "#2CA02C", "#D62728", "#9467BD",
+	"#8C564B", "#E377C2", "#7F7F7F", "#BCBD22", "#17BECF",
+}
+
 const (
 	margin                  = 20                // left and right margins
 	defaultDistance         = 180               // default distance between actors
 	defaultStepHeight       = 50                // default height for each step
 	actorFontSize           = 16                // actor font size
+	descriptionFontSize     = 10                // font size of the descriptions, sections and notes
+	baseFontSize            = 16                // font size in pixels of 1em, used to convert the font sizes to em
 	dashArraySize           = actorFontSize / 2 // actor line stroke dash-array size
 	descriptionOffset       = 7                 // text description offset against the step line
 	descriptionOffsetFactor = 2                 // how much is increased the offset for each line in a multiline description
+	databaseWidth           = 36                // width of the database actor cylinder
+	databaseHeight          = 30                // height of the database actor cylinder
+	databaseRY              = 5                 // vertical radius of the database actor cylinder ellipses
+	humanHeight             = 30                // height of the human actor figure
+	queueWidth              = 40                // width of the queue actor capsule
+	queueHeight             = 20                // height of the queue actor capsule
+	queueRX                 = 5                 // horizontal radius of the queue actor capsule ends
+	activationWidth         = 10                // width of the activation bars
+	activationPadding       = 5                 // space of the activation bars above their first step and below their last one
+	legendRowHeight         = 16                // height of each row of the legend
+	legendSampleWidth       = 30                // width of the arrow drawn in each row of the legend
+	actorCharWidth          = 9                 // estimated width of a character of an actor name
+	actorBoxHeight          = 24                // height of the box of a created actor
+	actorBoxPadding         = 6                 // horizontal padding of the box of a created actor
+	notePadding             = 8                 // padding around the text of a note
+	noteFold                = 8                 // size of the folded corner of a note
+	refTabWidth             = 30                // width of the "ref" tab of a reference fragment
+	refTabHeight            = 14                // height of the "ref" tab of a reference fragment
+	decisionSize            = 10                // half the width and height of the decision diamond
+	timeBreakHeight         = 20                // height of the step of a time break
+	timeBreakSize           = 8                 // half the width of the zig-zag of a time break
+	terminalSize            = 6                 // half the width of the terminal marker of the lifelines
+	durationOffset          = 14                // duration text offset below the step line
+	minSectionWidth         = 20                // minimum width of a section so it is always visible
+	timestampPadding        = 8                 // space between the timestamps and the first actor column
+	footerHeight            = 20                // height reserved for the footer
+	watermarkFontSize       = 64                // watermark font size
+	emptySectionHeight      = 12                // height of the placeholder drawn for empty sections
+	ctxCheckInterval        = 1000              // number of steps drawn between context cancellation checks
+	defaultSelfLoopHeight   = 15                // default height of the self-message loops
+	defaultSectionMargin    = 10                // default space between the sections of adjacent steps
+	defaultSelfLabelOffset  = 8                 // default distance from the actor to the description of a self-message dot
+	descriptionCharWidth    = 6                 // estimated width of a character of a description
+	labelTabPadding         = 4                 // horizontal padding of the text of a description drawn as a tab
+)
+
+// actorStyle defines how the actor header is drawn
+type actorStyle int
+
+const (
+	actorPlain    actorStyle = iota // only the actor name
+	actorDatabase                   // a database cylinder above the actor name
+	actorHuman                      // a stick figure above the actor name
+	actorQueue                      // a horizontal capsule above the actor name
+)
+
+// ArrowStyle defines how the line and the head of an arrow are drawn
+type ArrowStyle int
+
+const (
+	Solid  ArrowStyle = iota // solid line with a filled arrowhead
+	Dashed                   // dashed line with a filled arrowhead
+	Async                    // dashed line with an open arrowhead, e.g. for the asynchronous replies
+)
+
+// AnnotateFlags selects the elements that get ids and CSS classes
+type AnnotateFlags int
+
+const (
+	AnnotateActors       AnnotateFlags = 1 << iota // actor headers, lifelines and group dividers
+	AnnotateSteps                                  // arrows, notes, references, decisions and timestamps
+	AnnotateSections                               // section boxes and labels
+	AnnotateDescriptions                           // descriptions of the steps, notes and durations
+
+	AnnotateNone AnnotateFlags = 0
+	AnnotateAll                = AnnotateActors | AnnotateSteps | AnnotateSections | AnnotateDescriptions
 )
 
+// backReference is a curved arrow from a step back up to an earlier one
+type backReference struct {
+	from, to int // indexes of the steps
+	label    string
+}
+
+// messageType is a kind of message registered with RegisterMessageType
+type messageType struct {
+	key, color, label string
+	style             ArrowStyle
+}
+
+// activation is a bar over the lifeline of an actor while it is active
+type activation struct {
+	actor       string
+	first, last int  // indexes of the first and last steps
+	depth       int  // number of activations of the actor open when it started
+	open        bool // the actor has not been deactivated yet
+}
+
 type actor struct {
-	x float64
+	x     float64
+	style actorStyle
+	slug  string // unique name used in the CSS class of the actor
+	color string // palette color of the steps of the actor when coloring by actor
+	group string // name of the group of the actor
 }
 
 type section struct {
 	name           string
 	color          string
+	fillColor      string
+	borderColor    string
 	bordered       bool
+	dashed         bool
+	padding        int
+	textDown       bool // the vertical text reads from top to bottom
+	openIndex      int  // number of steps when the section was opened
+	closedEmpty    bool // the section was closed without any step
 	firstStepIndex *int
 	lastStepIndex  *int
 
@@ -41,6 +159,16 @@ type section struct {
 	height   int
 }
 
+// bbox is an estimated bounding box of an element
+type bbox struct {
+	x1, y1, x2, y2 float64
+}
+
+// overlaps returns true if both bounding boxes intersect
+func (b bbox) overlaps(o bbox) bool {
+	return b.x1 < o.x2 && o.x1 < b.x2 && b.y1 < o.y2 && o.y1 < b.y2
+}
+
 type Step struct {
 	// Text: Optional text displayed above the arrow or mark.
 	Text string
@@ -58,10 +186,97 @@ type Step struct {
 	// Pass an empty string to use the default color.
 	Color string
 
+	// TextColor: Optional CSS color value for the description.
+	//
+	// Pass an empty string to use the same color as the arrow.
+	TextColor string
+
+	// SelfStyle: Optional style used when Source and Target are the same actor.
+	//
+	// Valid styles are "dot" and "loop", an arrow that leaves and returns to the actor.
+	// Pass an empty string to use the style of the sequence, see SetSelfStyle.
+	SelfStyle string
+
+	// VerticalText: Optional flag to draw the description rotated 90 degrees beside the arrow,
+	// useful when the actors are too close for horizontal descriptions.
+	VerticalText bool
+
+	// CreatesTarget: Optional flag to mark that the step creates the Target actor.
+	//
+	// The Target actor box is drawn at the step, where the arrow lands, instead of at the top.
+	CreatesTarget bool
+
+	// Duration: Optional text displayed below the arrow or mark next to a clock icon.
+	Duration string
+
+	// DescriptionBelow: Optional text displayed below the arrow or mark,
+	// in addition to Text which is displayed above it.
+	DescriptionBelow string
+
+	// Timestamp: Optional text displayed in the left column at the height of the step,
+	// see SetShowTimestamps.
+	Timestamp string
+
+	// Opacity: Optional opacity between 0 and 1 applied to the whole step,
+	// e.g. to de-emphasize the context steps.
+	//
+	// Pass 0 to draw the step opaque.
+	Opacity float64
+
+	// NoArrow: Optional flag to draw the step as a plain line between the lifelines,
+	// without the dot and the arrowhead, e.g. for associations or alignment lines.
+	NoArrow bool
+
+	// DashPattern: Optional dash pattern of the line, a list of non-negative numbers separated
+	// by spaces or commas written as its stroke-dasharray, e.g. "4 2 1 2".
+	//
+	// Pass an empty string to draw a solid line.
+	DashPattern string
+
+	// Style: Optional style of the arrow, a solid line with a filled arrowhead by default.
+	//
+	// A DashPattern replaces the dash pattern of the dashed styles.
+	Style ArrowStyle
+
+	// SpaceBefore: Optional extra space in pixels added above the step,
+	// e.g. to visually group the steps without a divider.
+	SpaceBefore int
+
+	// SpaceAfter: Optional extra space in pixels added below the step.
+	SpaceAfter int
+
+	// YOffset: Optional vertical offset in pixels applied to the step after the layout,
+	// the following steps are not moved.
+	//
+	// The steps are always drawn in the order they were added, so when several of them
+	// land at the same height the later ones are drawn on top of the earlier ones.
+	YOffset float64
+
+	// Type: Optional key of a message type registered with RegisterMessageType,
+	// which sets the color and the style of the step when they are not set.
+	Type string
+
+	// Layers: Optional names of the layers of the step.
+	//
+	// The step is only generated when one of them is active, see SetActiveLayers.
+	// Steps without layers are always generated.
+	Layers []string
+
 	x1      float64 // Source Actor x
 	x2      float64 // Target Actor x
 	y       float64
+	height  int
 	section *section
+
+	autoColor     bool // the step has no color set
+	autoTextColor bool // the step has no description color set
+
+	note       bool     // the step is a note instead of an arrow
+	ref        bool     // the note is drawn as a reference fragment
+	noteActors []string // actors spanned by the note, all of them if empty
+	decision   bool     // the step is a decision node on the Source actor instead of an arrow
+	timeBreak  bool     // the step is a break in the lifeline of the Source actor instead of an arrow
+	number     int      // number of the arrow when the steps are numbered
 }
 
 type Sequence struct {
@@ -69,21 +284,159 @@ type Sequence struct {
 	actorsMap map[string]*actor // map[actorName]actor
 	sections  []*section
 	steps     []*Step
-
-	width, height       string // SVG width and height (not the viewport)
-	distance            int    // distance between actors
-	stepHeight          int    // height for each step
-	verticalSectionText bool   // whether to position the section text vertically at the left of each section
+	warnings  []string
+
+	width, height       string              // SVG width and height (not the viewport)
+	distance            int                 // distance between actors
+	stepHeight          int                 // height for each step
+	selfLoopHeight      int                 // height of the self-message loops
+	sectionMargin       int                 // space between the sections of adjacent steps, they overlap if negative
+	verticalSectionText bool                // whether to position the section text vertically at the left of each section
+	adaptiveStepHeight  bool                // whether the steps without description use half the step height
+	tightRepeatSpacing  float64             // factor applied to the step height of consecutive steps between the same actors
+	offsetX, offsetY    float64             // offset applied to the whole content of the diagram
+	activeLayers        []string            // layers of the steps to generate, all of them if empty
+	groupDividers       bool                // whether to draw a line between adjacent groups of actors
+	hideUnusedActors    bool                // whether to hide the actors that are not part of any step
+	suspensions         map[string][][2]int // map[actorName]ranges of step indexes where the actor is suspended
+	backReferences      []backReference     // arrows from steps back to earlier ones
+	activations         []activation        // activation bars of the actors, in the order they were activated
+	badDeactivations    []string            // actors deactivated without an open activation
+	messageTypes        []messageType       // kinds of messages referenced by the steps, in the order they were registered
+	legend              bool                // whether to draw the legend of the message types used by the steps
+	pinnedX             map[string]float64  // map[actorName]x of the actors with a fixed position
+	actorColors         map[string]string   // map[actorName]color of the actor names with a custom color
+	source              string              // source text embedded as metadata
+	meta                map[string]string   // key/value pairs embedded as metadata
+	maxActors, maxSteps int                 // maximum number of actors and steps, unlimited if zero
+	topPadding          int                 // space reserved above the actors
+	noteStyle           string              // shape of the notes: "rect" or "folded"
+	alignment           string              // alignment of the diagram when width and height are fixed
+	lineCap             string              // stroke-linecap of the step lines, the SVG default (butt) if empty
+	omitDefs            bool                // whether to omit the <defs> element, shared across diagrams
+	markers             string              // custom marker definitions replacing the built-in ones
+	emptySectionPolicy  string              // how to handle sections without steps: "drop", "error" or "keep"
+	distanceFraction    float64             // fraction of the SVG width shared by the actors to compute the distance
+	colorByActor        bool                // whether the steps without color use the color of their source actor
+	colorSeed           *int64              // seed used to shuffle the palette of the actors
+	defaultColor        string              // color used for the steps and sections without color
+	preserveWhitespace  bool                // whether to keep the leading, trailing and repeated spaces of the descriptions
+	showTimestamps      bool                // whether to draw the timestamps of the steps in a left column
+	maxDescriptionLines int                 // maximum number of lines of the descriptions, unlimited if zero
+	selfStyle           string              // style of the self-messages without their own style: "dot" or "loop"
+	selfLabelSide       string              // side of the actor where the self-message descriptions are placed
+	selfLabelOffset     int                 // distance from the actor to the description of a self-message dot
+	labelClampToArrow   bool                // whether to anchor the descriptions longer than their arrow at the source
+	labelStyle          string              // style of the descriptions of the arrows: "float" or "tab"
+	fontUnit            string              // unit of the font sizes: "px" or "em"
+	elementHook         func(el any) any    // post-processes the elements before encoding them
+	prefixActorInLabel  bool                // whether to prefix the descriptions with the source actor name
+	autonumber          bool                // whether to number the arrows
+	autonumberFormat    func(n int) string  // formats the number of the arrows, decimal if nil
+	lifelineTerminal    string              // marker at the end of the lifelines: "none", "bar" or "cross"
+	viewBox             *[4]float64         // viewBox used instead of the computed one: x, y, width and height
+	title               string              // title of the diagram, written as its accessible name
+	descriptionItalic   bool                // whether to draw the descriptions in italic
+	timeDirection       string              // direction of the time: "down" or "up"
+	autoLabelPlacement  bool                // whether to avoid overlapping horizontal section labels
+	xmlDeclaration      bool                // whether to prepend the XML declaration
+	stylesheet          string              // external CSS stylesheet referenced with a processing instruction
+	noDashSnap          bool                // whether to keep the height of the content instead of rounding it to the dash-array
+	footer              string              // text drawn at the bottom-left of the diagram
+	watermark           string              // text drawn across the center of the diagram
+	watermarkColor      string              // color of the watermark text
+	watermarkOpacity    float64             // opacity of the watermark text, between 0 and 1
+	noAnnotate          AnnotateFlags       // elements drawn without ids and classes
+	selectedAnnotate    AnnotateFlags       // elements selected with SetAnnotate, which also get the classes of the arrows and sections
 }
 
 func NewSequence() *Sequence {
 	return &Sequence{
-		actorsMap:  make(map[string]*actor),
-		width:      "100%",
-		height:     "100%",
-		distance:   defaultDistance,
-		stepHeight: defaultStepHeight,
+		actorsMap:       make(map[string]*actor),
+		width:           "100%",
+		height:          "100%",
+		distance:        defaultDistance,
+		stepHeight:      defaultStepHeight,
+		selfLoopHeight:  defaultSelfLoopHeight,
+		sectionMargin:   defaultSectionMargin,
+		selfLabelOffset: defaultSelfLabelOffset,
+		defaultColor:    DefaultColor,
+	}
+}
+
+// Options holds optional configuration for a new sequence,
+// the zero values keep the defaults of NewSequence.
+type Options struct {
+	Width               string  // SVG width, e.g. "100%" (default) or "900px".
+	Height              string  // SVG height, e.g. "100%" (default) or "600px".
+	Distance            int     // Distance between actors.
+	DistanceFraction    float64 // Fraction of the SVG width shared by the actors, see SetDistanceFraction.
+	StepHeight          int     // Height for each step.
+	TopPadding          int     // Space reserved above the actors.
+	VerticalSectionText bool    // Section text is drawn vertically at the left of each section.
+	Alignment           string  // Alignment of the diagram: "start" (default), "center" or "end".
+	NoteStyle           string  // Shape of the notes: "rect" (default) or "folded".
+	TimeDirection       string  // Direction of the time: "down" (default) or "up".
+	DefaultColor        string  // Optional CSS color value for the steps and sections without color.
+}
+
+// Validate returns an error if any of the options is invalid
+func (o Options) Validate() error {
+	switch {
+	case o.Distance < 0:
+		return fmt.Errorf("invalid distance: %d", o.Distance)
+	case o.DistanceFraction < 0 || o.DistanceFraction > 1 || math.IsNaN(o.DistanceFraction):
+		return fmt.Errorf("invalid distance fraction: %g", o.DistanceFraction)
+	case o.StepHeight < 0:
+		return fmt.Errorf("invalid step height: %d", o.StepHeight)
+	case o.TopPadding < 0:
+		return fmt.Errorf("invalid top padding: %d", o.TopPadding)
+	case !slices.Contains([]string{"", "start", "center", "end"}, o.Alignment):
+		return fmt.Errorf("invalid alignment: %s", o.Alignment)
+	case !slices.Contains([]string{"", "rect", "folded"}, o.NoteStyle):
+		return fmt.Errorf("invalid note style: %s", o.NoteStyle)
+	case !slices.Contains([]string{"", "down", "up"}, o.TimeDirection):
+		return fmt.Errorf("invalid time direction: %s", o.TimeDirection)
+	}
+	return nil
+}
+
+// NewSequenceWithOptions returns a new sequence configured with the given options.
+//
+// The options are not validated, use NewSequenceWithValidOptions to reject the invalid ones.
+func NewSequenceWithOptions(opts Options) *Sequence {
+	s := NewSequence()
+	if opts.Width != "" {
+		s.SetWidth(opts.Width)
+	}
+	if opts.Height != "" {
+		s.SetHeight(opts.Height)
 	}
+	if opts.Distance > 0 {
+		s.SetDistance(opts.Distance)
+	}
+	if opts.StepHeight > 0 {
+		s.SetStepHeight(opts.StepHeight)
+	}
+	if opts.DefaultColor != "" {
+		s.SetDefaultColor(opts.DefaultColor)
+	}
+	s.SetDistanceFraction(opts.DistanceFraction)
+	s.SetTopPadding(opts.TopPadding)
+	s.SetVerticalSectionText(opts.VerticalSectionText)
+	s.SetAlignment(opts.Alignment)
+	s.SetNoteStyle(opts.NoteStyle)
+	s.SetTimeDirection(opts.TimeDirection)
+	return s
+}
+
+// NewSequenceWithValidOptions returns a new sequence configured with the given options
+// or an error if any of them is invalid.
+func NewSequenceWithValidOptions(opts Options) (*Sequence, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	return NewSequenceWithOptions(opts), nil
 }
 
 // SetDistance sets the distance between actors
@@ -91,6 +444,41 @@ func (s *Sequence) SetDistance(d int) {
 	s.distance = d
 }
 
+// SetDistanceFraction derives the distance between actors from the SVG width
+// when it is set in pixels (e.g. "900" or "900px").
+//
+// The given fraction of the width (without margins) is shared by the actors,
+// pass 1 to use the whole width or 0 to use the fixed distance.
+// The fixed distance is also used when the width is a percentage or when
+// it leaves no room for the actors.
+// Negative and NaN fractions are ignored.
+func (s *Sequence) SetDistanceFraction(f float64) {
+	if f < 0 || math.IsNaN(f) {
+		return
+	}
+	s.distanceFraction = f
+}
+
+// SetColorByActor colors the steps without a color using a palette color
+// assigned to their source actor.
+func (s *Sequence) SetColorByActor(b bool) {
+	s.colorByActor = b
+}
+
+// SetColorSeed shuffles the palette used by SetColorByActor.
+// The same seed always assigns the same colors to the actors.
+func (s *Sequence) SetColorSeed(seed int64) {
+	s.colorSeed = &seed
+}
+
+// SetDefaultColor sets the color used for the steps and sections added afterwards without a color.
+func (s *Sequence) SetDefaultColor(color string) {
+	if color == "" {
+		color = DefaultColor
+	}
+	s.defaultColor = color
+}
+
 // SetWidth sets the SVG width.
 //
 // Any CSS value for size is valid, including pixels or percentages.
@@ -110,19 +498,414 @@ func (s *Sequence) SetStepHeight(h int) {
 	s.stepHeight = h
 }
 
+// SetSectionMargin sets the space left between the sections of adjacent steps,
+// 10 by default. Each section box is shortened by the margin at the bottom,
+// so a negative margin makes it longer and the adjacent sections overlap.
+func (s *Sequence) SetSectionMargin(m int) {
+	s.sectionMargin = m
+}
+
+// SetSelfLoopHeight sets the height of the self-messages drawn as a loop,
+// which is added to the height of their step. Non-positive heights are ignored.
+func (s *Sequence) SetSelfLoopHeight(h int) {
+	if h <= 0 {
+		return
+	}
+	s.selfLoopHeight = h
+}
+
 // SetVerticalSectionText sets the section text vertically on the left
 func (s *Sequence) SetVerticalSectionText(b bool) {
 	s.verticalSectionText = b
 }
 
+// SetAdaptiveStepHeight makes the steps without description use half the step height,
+// the steps with description keep the step height and expand with each line.
+func (s *Sequence) SetAdaptiveStepHeight(b bool) {
+	s.adaptiveStepHeight = b
+}
+
+// SetTightRepeatSpacing sets the factor applied to the step height of consecutive steps
+// that share the same source and target actors, so they appear grouped.
+//
+// The factor must be between 0 and 1, pass 0 to disable it.
+// Factors out of that range and NaN are ignored.
+func (s *Sequence) SetTightRepeatSpacing(factor float64) {
+	if factor < 0 || factor > 1 || math.IsNaN(factor) {
+		return
+	}
+	s.tightRepeatSpacing = factor
+}
+
+// SetContentOffset shifts the whole content of the diagram by (dx, dy).
+//
+// The viewBox grows so it still covers the offset content, a negative offset
+// moves its origin to the left or above so the content is not clipped.
+func (s *Sequence) SetContentOffset(dx, dy float64) {
+	s.offsetX = dx
+	s.offsetY = dy
+}
+
+// SetHideUnusedActors hides the actors that do not take part in any step,
+// such as actors declared with AddActors that never send or receive anything.
+func (s *Sequence) SetHideUnusedActors(b bool) {
+	s.hideUnusedActors = b
+}
+
+// SetEmbedSource embeds the given source text (e.g. the CFG used to generate the sequence)
+// in a <metadata> element so it can be regenerated later.
+func (s *Sequence) SetEmbedSource(source string) {
+	s.source = source
+}
+
+// SetMeta sets a key/value pair embedded in the <metadata> element, e.g. the author.
+func (s *Sequence) SetMeta(key, value string) {
+	if s.meta == nil {
+		s.meta = make(map[string]string)
+	}
+	s.meta[key] = value
+}
+
+// Meta returns the key/value pairs embedded in the <metadata> element
+func (s *Sequence) Meta() map[string]string {
+	return s.meta
+}
+
+// SetLimits sets the maximum number of actors and steps allowed in the sequence.
+// Generating a sequence that exceeds them returns an error.
+//
+// Pass 0 for unlimited.
+func (s *Sequence) SetLimits(maxActors, maxSteps int) {
+	s.maxActors = maxActors
+	s.maxSteps = maxSteps
+}
+
+// SetTopPadding sets the space reserved above the actors, so tall glyphs
+// such as emojis in their names are not clipped by the top edge.
+func (s *Sequence) SetTopPadding(p int) {
+	s.topPadding = p
+}
+
+// SetNoteStyle sets the shape of the notes.
+//
+// Valid styles are "rect" (default) and "folded", the classic UML note with a folded corner.
+func (s *Sequence) SetNoteStyle(style string) {
+	s.noteStyle = style
+}
+
+// SetAlignment sets the alignment of the diagram inside the SVG viewport
+// when its width and height do not match the aspect ratio of the diagram.
+//
+// Valid alignments are "start" (default), "center" and "end".
+func (s *Sequence) SetAlignment(alignment string) {
+	s.alignment = alignment
+}
+
+// SetLineCap sets the shape of the ends of the step lines.
+//
+// Valid shapes are "butt" (default), "round" and "square", other values are ignored.
+func (s *Sequence) SetLineCap(lineCap string) {
+	if !slices.Contains([]string{"butt", "round", "square"}, lineCap) {
+		return
+	}
+	s.lineCap = lineCap
+}
+
+// SetIncludeDefs sets whether the <defs> element with the style and the markers
+// is generated (default), see SharedDefs to share it across diagrams.
+func (s *Sequence) SetIncludeDefs(b bool) {
+	s.omitDefs = !b
+}
+
+// SetMarkers replaces the built-in arrow markers with custom marker definitions.
+//
+// The XML must define the markers with the ids "seq-dot" (start of the arrows)
+// and "seq-arrow" (end of the arrows), e.g.:
+//
+//	<marker id="seq-dot" ...>...</marker>
+//	<marker id="seq-arrow" ...>...</marker>
+//
+// The steps with the Async style reference the marker with the id "seq-arrow-open",
+// the built-in one is added when it is not defined.
+//
+// Pass an empty string to restore the built-in markers.
+func (s *Sequence) SetMarkers(defsXML string) error {
+	if defsXML == "" {
+		s.markers = ""
+		return nil
+	}
+
+	ids, err := markerIDs(defsXML)
+	if err != nil {
+		return fmt.Errorf("invalid markers: %v", err)
+	}
+	for _, id := range []string{"seq-dot", "seq-arrow"} {
+		if !ids[id] {
+			return fmt.Errorf("invalid markers: missing marker with id %q", id)
+		}
+	}
+
+	s.markers = defsXML
+	return nil
+}
+
+// markerIDs returns the ids of the elements defined by the marker definitions
+func markerIDs(defsXML string) (map[string]bool, error) {
+	ids := make(map[string]bool)
+	decoder := xml.NewDecoder(strings.NewReader("<defs>" + defsXML + "</defs>"))
+	for {
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			return ids, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if el, ok := tok.(xml.StartElement); ok {
+			for _, attr := range el.Attr {
+				if attr.Name.Local == "id" {
+					ids[attr.Value] = true
+				}
+			}
+		}
+	}
+}
+
+// SetEmptySectionPolicy sets how the sections without steps are handled.
+//
+// Valid policies are:
+//   - "drop":  the sections are silently removed (default).
+//   - "error": generating the sequence returns an error naming the section.
+//   - "keep":  the sections are drawn as a small placeholder box.
+func (s *Sequence) SetEmptySectionPolicy(policy string) {
+	s.emptySectionPolicy = policy
+}
+
+// SetSelfStyle sets the style of the self-messages that do not set their own SelfStyle.
+//
+// Valid styles are "dot" (default) and "loop", an arrow that leaves the lifeline to the right
+// and returns to it below, with the description at the right of the loop.
+func (s *Sequence) SetSelfStyle(style string) {
+	s.selfStyle = style
+}
+
+// SetSelfLabelSide sets the side of the actor where the description
+// of the self-messages drawn as a dot is placed.
+//
+// Valid sides are "right" (default) and "left".
+func (s *Sequence) SetSelfLabelSide(side string) {
+	s.selfLabelSide = side
+}
+
+// SetSelfLabelOffset sets the distance from the actor to the description
+// of the self-messages drawn as a dot, 8 by default. Negative values are ignored.
+func (s *Sequence) SetSelfLabelOffset(offset int) {
+	if offset < 0 {
+		return
+	}
+	s.selfLabelOffset = offset
+}
+
+// SetAnnotate sets which elements get ids and CSS classes, all of them by default.
+//
+// Leaving out the elements that are not styled or scripted reduces the size of the output,
+// the ids of the markers are always kept since the arrows reference them.
+//
+// The lines of the arrows and the section boxes and labels have no class by default,
+// they get the "seq-step", "seq-section" and "seq-section-label" classes only when
+// their elements are selected here.
+func (s *Sequence) SetAnnotate(flags AnnotateFlags) {
+	s.noAnnotate = AnnotateAll &^ flags
+	s.selectedAnnotate = flags
+}
+
+// SetLabelClampToArrow sets whether the descriptions estimated to be wider than
+// their arrow are anchored at the source actor instead of centered on the arrow,
+// so they extend along the arrow and do not spill over the column behind the source.
+func (s *Sequence) SetLabelClampToArrow(b bool) {
+	s.labelClampToArrow = b
+}
+
+// SetLabelStyle sets how the descriptions of the arrows are drawn.
+//
+// Valid styles are "float" (default), which places the description above the arrow,
+// and "tab", which draws it inside a box centered on the arrow, splitting the arrow
+// line around the box. The descriptions wider than their arrow are always floating.
+func (s *Sequence) SetLabelStyle(style string) {
+	s.labelStyle = style
+}
+
+// SetFontUnit sets the unit of the font sizes.
+//
+// Valid units are "px" (default), which writes the font sizes as unitless pixels,
+// and "em", which writes them relative to a 16px font so they scale with the page
+// embedding the diagram. The layout is always computed for the 16px font.
+func (s *Sequence) SetFontUnit(unit string) {
+	s.fontUnit = unit
+}
+
+// SetElementHook sets a function that post-processes the elements of the diagram before encoding them.
+//
+// The hook is called once for every top-level element, in drawing order: the metadata,
+// the definitions, the background, the actors (each one a "g" element), the sections,
+// the steps, the footer and the watermark, before they are wrapped by the offset set
+// with SetContentOffset. The element returned by the hook
+// replaces it, it can be a wrapper or any other value encodable by encoding/xml, and returning
+// nil drops the element. The elements are values of unexported types, use ElementName,
+// AddElementClass and SetElementAttr to inspect and modify them.
+func (s *Sequence) SetElementHook(hook func(el any) any) {
+	s.elementHook = hook
+}
+
+// SetPrefixActorInLabel sets whether the descriptions of the steps are prefixed
+// with the name of their source actor, drawn as "Source: description".
+//
+// The notes, references and decisions, and the steps without description, are not prefixed.
+func (s *Sequence) SetPrefixActorInLabel(b bool) {
+	s.prefixActorInLabel = b
+}
+
+// SetAutonumber sets whether the arrows are numbered, starting at 1,
+// with the number drawn before their description.
+func (s *Sequence) SetAutonumber(b bool) {
+	s.autonumber = b
+}
+
+// SetAutonumberFormat sets the function that formats the number of the arrows
+// when they are numbered, e.g. to zero-pad them or use letters. Decimal by default.
+func (s *Sequence) SetAutonumberFormat(format func(n int) string) {
+	s.autonumberFormat = format
+}
+
+// SetLifelineTerminal sets the marker drawn at the end of the lifelines.
+//
+// Valid terminals are:
+//   - "none": the lifelines just end (default).
+//   - "bar":  a horizontal bar grounding the lifeline.
+//   - "cross": the UML destruction cross.
+func (s *Sequence) SetLifelineTerminal(terminal string) {
+	s.lifelineTerminal = terminal
+}
+
+// SetViewBox sets the viewBox of the SVG, used verbatim instead of the one computed
+// from the content, e.g. to crop a region of the diagram or to add some padding.
+// The background covers the viewBox.
+//
+// Returns an error if the width or the height is negative.
+func (s *Sequence) SetViewBox(x, y, width, height float64) error {
+	if width < 0 || height < 0 {
+		return fmt.Errorf("invalid viewBox size: %gx%g", width, height)
+	}
+	s.viewBox = &[4]float64{x, y, width, height}
+	return nil
+}
+
+// SetTitle sets the title of the diagram, written as the SVG <title> element
+// so it is the accessible name of the diagram, and as the caption of GenerateHTML.
+func (s *Sequence) SetTitle(title string) {
+	s.title = title
+}
+
+// SetDescriptionItalic sets whether the descriptions are drawn in italic,
+// the texts of the steps, notes, references and decisions. Upright by default.
+func (s *Sequence) SetDescriptionItalic(b bool) {
+	s.descriptionItalic = b
+}
+
+// SetTimeDirection sets the direction in which the time flows
+//
+// Valid directions are "down" (default) and "up", which draws the first step
+// at the bottom and the actors below their lifelines.
+func (s *Sequence) SetTimeDirection(direction string) {
+	s.timeDirection = direction
+}
+
+// SetAutoLabelPlacement avoids overlapping horizontal section labels
+// by drawing the overlapping ones vertically at the left of their section.
+func (s *Sequence) SetAutoLabelPlacement(b bool) {
+	s.autoLabelPlacement = b
+}
+
+// SetShowTimestamps draws the timestamps of the steps right-aligned in a column
+// reserved at the left of the actors.
+func (s *Sequence) SetShowTimestamps(b bool) {
+	s.showTimestamps = b
+}
+
+// SetPreserveWhitespace keeps the leading, trailing and repeated spaces
+// of the descriptions, which are collapsed by default.
+func (s *Sequence) SetPreserveWhitespace(b bool) {
+	s.preserveWhitespace = b
+}
+
+// SetMaxDescriptionLines sets the maximum number of lines of the step descriptions.
+// Longer descriptions are truncated with an ellipsis and the full text is shown as a tooltip.
+//
+// Pass 0 for unlimited.
+func (s *Sequence) SetMaxDescriptionLines(n int) {
+	s.maxDescriptionLines = n
+}
+
+// SetXMLDeclaration prepends the XML declaration (<?xml version="1.0" encoding="UTF-8"?>)
+// to the generated SVG, required by some consumers of standalone SVG files.
+func (s *Sequence) SetXMLDeclaration(b bool) {
+	s.xmlDeclaration = b
+}
+
+// SetStylesheet references an external CSS stylesheet from the generated SVG
+// with an xml-stylesheet processing instruction. The default CSS is still embedded.
+func (s *Sequence) SetStylesheet(href string) {
+	s.stylesheet = href
+}
+
+// SetSnapHeightToDash sets whether the height of the diagram is rounded up to fit
+// the dash-array of the lifelines (default), disable it to keep the height of the content.
+func (s *Sequence) SetSnapHeightToDash(b bool) {
+	s.noDashSnap = !b
+}
+
+// SetFooter sets a small text drawn at the bottom-left of the diagram,
+// e.g. the generation date. The diagram height grows to fit it.
+func (s *Sequence) SetFooter(footer string) {
+	s.footer = footer
+}
+
+// WatermarkConfig holds optional configuration for the watermark.
+type WatermarkConfig struct {
+	Color   string   // Optional CSS color value (e.g., " #ff0000", "red"), "#CCCCCC" if empty.
+	Opacity *float64 // Optional opacity between 0 and 1, 0.3 if nil.
+}
+
+// SetWatermark sets a large translucent text drawn rotated across the center of the diagram,
+// e.g. "DRAFT".
+//
+// Parameters:
+//   - text:   Text of the watermark, pass an empty string to remove it.
+//   - config: Optional 'WatermarkConfig' configuration. Pass nil to use defaults.
+func (s *Sequence) SetWatermark(text string, cfg *WatermarkConfig) {
+	s.watermark = text
+	s.watermarkColor, s.watermarkOpacity = "#CCCCCC", 0.3
+	if cfg != nil {
+		if cfg.Color != "" {
+			s.watermarkColor = cfg.Color
+		}
+		if cfg.Opacity != nil {
+			s.watermarkOpacity = *cfg.Opacity
+		}
+	}
+}
+
 // AddActors adds the given actors to the sequence, in order.
 //
 // Use this to ensure the order of the actors in the sequence.
+// The spaces around the names are trimmed and the names with control characters
+// are ignored, both are reported by Warnings.
 func (s *Sequence) AddActors(actors ...string) {
 	// add new actors to the s.actors map and ensure that there are
 	// no duplicates in the actors input
 	newActors := []string{}
 	for _, a := range actors {
+		a = s.normalizeActor(a)
 		if a == "" {
 			continue
 		}
@@ -147,10 +930,59 @@ func (s *Sequence) AddActors(actors ...string) {
 	s.actors = append(newActors, remaining...)
 }
 
+// normalizeActor returns the actor name without the spaces around it,
+// or an empty name if it contains control characters
+func (s *Sequence) normalizeActor(name string) string {
+	trimmed := strings.TrimSpace(name)
+	if strings.ContainsFunc(trimmed, unicode.IsControl) {
+		s.warnings = append(s.warnings, fmt.Sprintf("actor %q ignored: the name contains control characters", name))
+		return ""
+	}
+	if trimmed != name {
+		s.warnings = append(s.warnings, fmt.Sprintf("actor %q trimmed to %q", name, trimmed))
+	}
+	return trimmed
+}
+
+// Warnings returns the issues found while building the sequence
+func (s *Sequence) Warnings() []string {
+	return s.warnings
+}
+
+// Lint returns informational issues about the design of the sequence,
+// like the actors that only send or only receive messages.
+func (s *Sequence) Lint() []string {
+	sends := make(map[string]bool)
+	receives := make(map[string]bool)
+	for _, st := range s.steps {
+		if !st.isArrow() {
+			continue
+		}
+		sends[st.Source] = true
+		receives[st.Target] = true
+	}
+
+	var issues []string
+	for _, name := range s.actors {
+		switch {
+		case sends[name] && !receives[name]:
+			issues = append(issues, fmt.Sprintf("actor %q only sends messages", name))
+		case receives[name] && !sends[name]:
+			issues = append(issues, fmt.Sprintf("actor %q only receives messages", name))
+		}
+	}
+	return issues
+}
+
 // AppendActors ensures that an actor exists
-// if it does not, the actor is appended (thus appears the last)
+// if it does not, the actor is appended (thus appears the last).
+// The names are normalized as in AddActors.
 func (s *Sequence) AppendActors(actors ...string) {
 	for _, a := range actors {
+		a = s.normalizeActor(a)
+		if a == "" {
+			continue
+		}
 		if !slices.Contains(s.actors, a) {
 			s.actors = append(s.actors, a)
 			s.actorsMap[a] = &actor{}
@@ -158,32 +990,215 @@ func (s *Sequence) AppendActors(actors ...string) {
 	}
 }
 
-// Actors returns the current list of actors
-func (s *Sequence) Actors() []string {
-	return s.actors
+// AddDatabaseActor ensures that an actor exists and draws it as a database cylinder.
+// If the actor does not exist, it is appended (thus appears the last).
+func (s *Sequence) AddDatabaseActor(name string) {
+	name = s.normalizeActor(name)
+	if name == "" {
+		return
+	}
+	s.AppendActors(name)
+	s.actorsMap[name].style = actorDatabase
 }
 
-// AddStep adds a new step to the sequence diagram.
-func (s *Sequence) AddStep(step Step) {
-	if step.Color == "" {
-		step.Color = "#000000"
+// AddHumanActor ensures that an actor exists and draws it as a human stick figure.
+// If the actor does not exist, it is appended (thus appears the last).
+func (s *Sequence) AddHumanActor(name string) {
+	name = s.normalizeActor(name)
+	if name == "" {
+		return
 	}
+	s.AppendActors(name)
+	s.actorsMap[name].style = actorHuman
+}
 
-	var y float64
-	if len(s.steps) > 0 {
-		// start with last step 'y' value
-		y = s.steps[len(s.steps)-1].y
-	} else {
-		// first step 'y' value
-		y = actorFontSize + 2
+// AddQueueActor ensures that an actor exists and draws it as a queue, a horizontal capsule.
+// If the actor does not exist, it is appended (thus appears the last).
+func (s *Sequence) AddQueueActor(name string) {
+	name = s.normalizeActor(name)
+	if name == "" {
+		return
+	}
+	s.AppendActors(name)
+	s.actorsMap[name].style = actorQueue
+}
+
+// AddActorGroup ensures that the actors exist and belong to the named group.
+// The actors that do not exist are appended (thus appear the last), in order.
+//
+// The actors of a group should be adjacent, see SetGroupDividers.
+func (s *Sequence) AddActorGroup(name string, actors ...string) {
+	for _, a := range actors {
+		a = s.normalizeActor(a)
+		if a == "" {
+			continue
+		}
+		s.AppendActors(a)
+		s.actorsMap[a].group = name
+	}
+}
+
+// SetGroupDividers draws a light vertical line between adjacent groups of actors
+func (s *Sequence) SetGroupDividers(b bool) {
+	s.groupDividers = b
+}
+
+// Actors returns the current list of actors
+func (s *Sequence) Actors() []string {
+	return s.actors
+}
+
+// ActorIndex returns the position of the actor in the list of actors
+// and whether it was found, the name is normalized as in AddActors
+func (s *Sequence) ActorIndex(name string) (int, bool) {
+	idx := slices.Index(s.actors, s.normalizeActor(name))
+	return idx, idx >= 0
+}
+
+// SetActiveLayers generates only the steps without layers and the ones
+// in any of the given layers, pass no layers to generate all the steps.
+//
+// Actors and sections left without steps are not generated.
+func (s *Sequence) SetActiveLayers(layers ...string) {
+	s.activeLayers = layers
+}
+
+// inActiveLayers returns true if the step has no layers or any of them is active
+func (s *Sequence) inActiveLayers(st Step) bool {
+	return len(st.Layers) == 0 || slices.ContainsFunc(st.Layers, func(l string) bool {
+		return slices.Contains(s.activeLayers, l)
+	})
+}
+
+// Activate starts an activation bar over the lifeline of the actor at the next step added,
+// which lasts until the actor is deactivated. Activating an active actor again stacks
+// a second bar, slightly offset to the right, e.g. for re-entrant calls.
+//
+// If the actor does not exist, it is appended (thus appears the last).
+func (s *Sequence) Activate(name string) {
+	name = s.normalizeActor(name)
+	if name == "" {
+		return
+	}
+	s.AppendActors(name)
+	depth := 0
+	for _, a := range s.activations {
+		if a.actor == name && a.open {
+			depth++
+		}
+	}
+	s.activations = append(s.activations, activation{actor: name, first: len(s.steps), depth: depth, open: true})
+}
+
+// Deactivate ends the last activation of the actor at the last step added,
+// the activations without steps are not drawn.
+//
+// Generating the sequence returns an error if the actor is not active.
+func (s *Sequence) Deactivate(name string) {
+	name = s.normalizeActor(name)
+	for i := len(s.activations) - 1; i >= 0; i-- {
+		a := &s.activations[i]
+		if a.actor == name && a.open {
+			a.last = len(s.steps) - 1
+			a.open = false
+			return
+		}
+	}
+	s.badDeactivations = append(s.badDeactivations, name)
+}
+
+// RegisterMessageType registers a kind of message that the steps reference by its key
+// with Step.Type, e.g. "http" or "event", drawn with the color and style and described
+// by the label in the legend, see SetLegend. Registering a key again replaces it.
+func (s *Sequence) RegisterMessageType(key, color string, style ArrowStyle, label string) {
+	t := messageType{key: key, color: color, label: label, style: style}
+	if i := slices.IndexFunc(s.messageTypes, func(t messageType) bool { return t.key == key }); i >= 0 {
+		s.messageTypes[i] = t
+		return
+	}
+	s.messageTypes = append(s.messageTypes, t)
+}
+
+// SetLegend sets whether to draw a legend at the bottom-left of the diagram with the
+// message types used by the steps, in the order they were registered.
+// The diagram height grows to fit it.
+func (s *Sequence) SetLegend(b bool) {
+	s.legend = b
+}
+
+// SuspendActor leaves a gap in the lifeline of the actor, to indicate that it is suspended,
+// from the step at index fromStep to the step at index toStep (both included).
+//
+// The indexes start at 0 and follow the order in which the steps are added.
+// The name of the actor is normalized as in AddActors.
+func (s *Sequence) SuspendActor(name string, fromStep, toStep int) {
+	name = s.normalizeActor(name)
+	if name == "" {
+		return
+	}
+	if s.suspensions == nil {
+		s.suspensions = make(map[string][][2]int)
+	}
+	s.suspensions[name] = append(s.suspensions[name], [2]int{fromStep, toStep})
+}
+
+// AddBackReference adds a curved arrow from the step at index fromStep back up
+// to the earlier step at index toStep, drawn at the left of the lifeline of the source
+// actor of fromStep with the label next to it, e.g. to indicate a retry.
+//
+// The indexes start at 0 and follow the order in which the steps are added.
+func (s *Sequence) AddBackReference(fromStep, toStep int, label string) {
+	s.backReferences = append(s.backReferences, backReference{from: fromStep, to: toStep, label: label})
+}
+
+// SetActorX pins the actor to the given x coordinate instead of the computed one.
+//
+// The actors that are not pinned are spread evenly between the pinned ones,
+// the pinned positions must increase with the order of the actors.
+// The name of the actor is normalized as in AddActors.
+func (s *Sequence) SetActorX(name string, x float64) {
+	name = s.normalizeActor(name)
+	if name == "" {
+		return
+	}
+	if s.pinnedX == nil {
+		s.pinnedX = make(map[string]float64)
+	}
+	s.pinnedX[name] = x
+}
+
+// SetActorColor sets the color of the name of the actor, a CSS color value.
+//
+// The actors without color use the default text color.
+// The name of the actor is normalized as in AddActors.
+func (s *Sequence) SetActorColor(name, color string) {
+	name = s.normalizeActor(name)
+	if name == "" {
+		return
+	}
+	if s.actorColors == nil {
+		s.actorColors = make(map[string]string)
+	}
+	s.actorColors[name] = color
+}
+
+// AddStep adds a new step to the sequence diagram.
+// The names of its actors are normalized as in AddActors.
+func (s *Sequence) AddStep(step Step) {
+	if step.Color == "" {
+		step.Color = s.defaultColor
+		step.autoColor = true
+	}
+	if step.TextColor == "" {
+		step.TextColor = step.Color
+		step.autoTextColor = step.autoColor
 	}
-	// take into account multiline descriptions
-	incr := len(strings.Split(step.Text, "\n")) - 1
-	y += float64(s.stepHeight) + float64((descriptionOffset*descriptionOffsetFactor)*incr)
-	step.y = y
 
 	// iterate over open sections to associate
 	for _, sec := range s.sections {
+		if sec.closedEmpty {
+			continue
+		}
 		if sec.firstStepIndex == nil {
 			idx := len(s.steps)
 			sec.firstStepIndex = &idx
@@ -193,6 +1208,18 @@ func (s *Sequence) AddStep(step Step) {
 		}
 	}
 
+	// normalize the actors as in AddActors
+	step.Source, step.Target = s.normalizeActor(step.Source), s.normalizeActor(step.Target)
+	if step.note {
+		actors := []string{}
+		for _, a := range step.noteActors {
+			if a = s.normalizeActor(a); a != "" {
+				actors = append(actors, a)
+			}
+		}
+		step.noteActors = actors
+		s.AppendActors(step.noteActors...)
+	}
 	if step.Source != "" {
 		s.AppendActors(step.Source)
 	}
@@ -203,10 +1230,52 @@ func (s *Sequence) AddStep(step Step) {
 	s.steps = append(s.steps, &step)
 }
 
+// AddNote adds a note over the given actors to the sequence diagram.
+//
+// The note spans from the leftmost to the rightmost of the actors.
+// If no actors are given, the note spans over all the actors of the sequence.
+func (s *Sequence) AddNote(text string, actors ...string) {
+	actors = slices.DeleteFunc(slices.Clone(actors), func(a string) bool { return a == "" })
+	s.AddStep(Step{Text: text, note: true, noteActors: actors})
+}
+
+// AddRef adds a reference fragment over the given actors to the sequence diagram:
+// a box with a "ref" tab and the label of another interaction, e.g. "see Login".
+//
+// The fragment spans like a note, see AddNote.
+func (s *Sequence) AddRef(label string, actors ...string) {
+	actors = slices.DeleteFunc(slices.Clone(actors), func(a string) bool { return a == "" })
+	s.AddStep(Step{Text: label, note: true, ref: true, noteActors: actors})
+}
+
+// AddDecision adds a decision node to the sequence diagram: a diamond
+// on the lifeline of the actor with the question next to it.
+//
+// The following steps can be colored to indicate the branches.
+func (s *Sequence) AddDecision(actor, question string) {
+	s.AddStep(Step{Text: question, Source: actor, Target: actor, decision: true})
+}
+
+// AddTimeBreak adds a time break to the sequence diagram: a zig-zag across
+// the lifeline of the actor indicating that some time elapsed for it.
+//
+// The time break takes less space than a step.
+func (s *Sequence) AddTimeBreak(actor string) {
+	s.AddStep(Step{Source: actor, Target: actor, timeBreak: true})
+}
+
 // SectionConfig holds optional configuration for a section.
 type SectionConfig struct {
 	Color         string // Optional CSS color value (e.g., " #ff0000", "red").
+	FillColor     string // Optional CSS color value of the background, defaults to Color.
+	BorderColor   string // Optional CSS color value of the border, defaults to Color.
 	WithoutBorder bool   // Section is drawn without a border.
+	BorderDashed  bool   // Section border is dashed instead of solid.
+	Padding       int    // Space added inside the section above and below its steps.
+
+	// VerticalDirection is the reading direction of the section text when it is vertical,
+	// either "up" (default) or "down".
+	VerticalDirection string
 }
 
 // OpenSection opens a new section to the sequence diagram.
@@ -221,10 +1290,10 @@ func (s *Sequence) OpenSection(name string, cfg *SectionConfig) {
 	}
 
 	sec := &section{
-		name:     name,
-		color:    "#000000",
-		bordered: true,
-		height:   -10, // negative margin between steps so sections dont overlap
+		name:      name,
+		color:     s.defaultColor,
+		bordered:  true,
+		openIndex: len(s.steps),
 	}
 
 	if cfg != nil {
@@ -232,6 +1301,17 @@ func (s *Sequence) OpenSection(name string, cfg *SectionConfig) {
 			sec.color = cfg.Color
 		}
 		sec.bordered = !cfg.WithoutBorder
+		sec.dashed = cfg.BorderDashed
+		sec.padding = cfg.Padding
+		sec.textDown = cfg.VerticalDirection == "down"
+		sec.fillColor = cfg.FillColor
+		sec.borderColor = cfg.BorderColor
+	}
+	if sec.fillColor == "" {
+		sec.fillColor = sec.color
+	}
+	if sec.borderColor == "" {
+		sec.borderColor = sec.color
 	}
 
 	s.sections = append(s.sections, sec)
@@ -241,6 +1321,11 @@ func (s *Sequence) OpenSection(name string, cfg *SectionConfig) {
 func (s *Sequence) CloseSection() {
 	for i := len(s.sections) - 1; i >= 0; i-- {
 		sec := s.sections[i]
+		// empty sections are only closed when they are not silently dropped
+		if sec.firstStepIndex == nil && !sec.closedEmpty && s.keepEmptySections() {
+			sec.closedEmpty = true
+			return
+		}
 		// close the last section added that has any step
 		if sec.firstStepIndex != nil && sec.lastStepIndex == nil {
 			idx := len(s.steps) - 1
@@ -265,233 +1350,1921 @@ func (s *Sequence) CloseAllSections() {
 	for _, sec := range s.sections {
 		if sec.firstStepIndex != nil && sec.lastStepIndex != nil {
 			complete = append(complete, sec)
+		} else if sec.firstStepIndex == nil && s.keepEmptySections() {
+			// handled by the empty section policy
+			sec.closedEmpty = true
+			complete = append(complete, sec)
 		}
 	}
 	s.sections = complete
 }
 
-// Generate generates a new SVG sequence
-func (s *Sequence) Generate() (string, error) {
-	if len(s.actors) == 0 {
-		return "", fmt.Errorf("sequence has no actors")
-	}
-	if len(s.steps) == 0 {
-		return "", fmt.Errorf("sequence has no steps")
-	}
-	err := s.setup()
-	if err != nil {
-		return "", err
-	}
-
-	totalWidth := s.totalWidth()
-	totalHeight := s.totalHeight()
-
-	root := svg{
-		Xmlns:               "http://www.w3.org/2000/svg",
-		Width:               s.width,
-		Height:              s.height,
-		ViewBox:             fmt.Sprintf("0 0 %d %d", totalWidth, totalHeight),
-		PreserveAspectRatio: "xMinYMin meet",
+// Filter returns a new sequence with the same configuration containing only the steps
+// for which keep returns true.
+//
+// Actors without steps and sections without steps are removed from the new sequence.
+func (s *Sequence) Filter(keep func(Step) bool) *Sequence {
+	ns := *s
+	ns.actors = nil
+	ns.actorsMap = make(map[string]*actor)
+	ns.sections = nil
+	ns.steps = nil
+
+	// do not share the configuration with the original sequence
+	ns.warnings = slices.Clone(s.warnings)
+	ns.activeLayers = slices.Clone(s.activeLayers)
+	ns.badDeactivations = slices.Clone(s.badDeactivations)
+	ns.messageTypes = slices.Clone(s.messageTypes)
+	ns.pinnedX = maps.Clone(s.pinnedX)
+	ns.actorColors = maps.Clone(s.actorColors)
+	ns.meta = maps.Clone(s.meta)
+
+	// keep the steps, remembering their new index
+	newIndex := make(map[int]int)
+	used := make(map[string]bool)
+	for i, st := range s.steps {
+		if !keep(*st) {
+			continue
+		}
+		newIndex[i] = len(ns.steps)
+		step := *st
+		ns.steps = append(ns.steps, &step)
+		used[st.Source] = true
+		used[st.Target] = true
+		for _, a := range st.noteActors {
+			used[a] = true
+		}
 	}
 
-	// Definitions
-	root.Elements = append(root.Elements,
-		svgDefs{
-			Elements: []any{
-				svgStyle{Content: defaultCSS},
-
-				marker{
-					ID: "seq-dot", ViewBox: "0 0 10 10", MarkerWidth: 5, MarkerHeight: 5, RefX: 5, RefY: 5,
-					Elements: []any{
-						circle{CX: 5, CY: 5, R: 3, Fill: "context-fill"},
-					},
-				},
-
-				marker{
-					ID: "seq-arrow", ViewBox: "0 0 10 10", MarkerWidth: 5, MarkerHeight: 5, RefX: 5, RefY: 5, Orient: "auto-start-reverse",
-					Elements: []any{
-						path{D: "M 0 0 L 10 5 L 0 10 z", Fill: "context-fill"},
-					},
-				},
-			},
-		})
-
-	// Background
-	root.Elements = append(root.Elements,
-		rect{X: 0, Y: 0, Width: float64(totalWidth), Height: float64(totalHeight), Fill: "#FFFFFF"},
-	)
-
-	// Draw actors
-	x := margin + s.distance/2
-	y := actorFontSize + 2
+	// keep the actors in the same order
 	for _, name := range s.actors {
-		a := s.actorsMap[name]
-
-		root.Elements = append(root.Elements,
-			// Actor line
-			line{X1: float64(x), Y1: float64(y + dashArraySize), X2: float64(x), Y2: float64(totalHeight), Stroke: "#CCCCCC", StrokeDasharray: fmt.Sprintf("%[1]d %[1]d", dashArraySize), StrokeWidth: 2},
-			// Actor text
-			text{X: float64(x), Y: float64(y), FontSize: strconv.Itoa(actorFontSize), Stroke: "none", Fill: "#000000", TextAnchor: "middle", Content: name},
-		)
-
-		a.x = float64(x)
-		x += s.distance
+		if used[name] {
+			ns.actors = append(ns.actors, name)
+			ns.actorsMap[name] = &actor{style: s.actorsMap[name].style, group: s.actorsMap[name].group}
+		}
 	}
 
-	// Compute steps and section values
-	for _, st := range s.steps {
-		srcAct := s.actorsMap[st.Source]
-		tgtAct := s.actorsMap[st.Target]
-		st.x1 = srcAct.x
-		st.x2 = tgtAct.x
-
-		if st.section != nil {
-			stHeight := s.getHeight(st)
-			st.section.height += stHeight
-
-			minSecY := max(0, st.y-float64(stHeight)+float64(s.stepHeight)/2.0)
-			if st.section.y == 0 || st.section.y > minSecY {
-				st.section.y = minSecY
+	// keep the sections that still have steps, re-indexing them
+	newSection := make(map[*section]*section)
+	for _, sec := range s.sections {
+		nsec := &section{name: sec.name, color: sec.color, fillColor: sec.fillColor, borderColor: sec.borderColor, bordered: sec.bordered, dashed: sec.dashed, padding: sec.padding, textDown: sec.textDown, closedEmpty: sec.closedEmpty}
+		for i := range sec.openIndex {
+			if _, ok := newIndex[i]; ok {
+				nsec.openIndex++
 			}
-
-			minSecX := max(1.0, min(st.x1, st.x2)-float64(s.distance/2.0))
-			if st.section.x == 0 || st.section.x > minSecX {
-				st.section.x = minSecX
+		}
+		if sec.firstStepIndex != nil {
+			last := len(s.steps) - 1
+			if sec.lastStepIndex != nil {
+				last = *sec.lastStepIndex
 			}
-
-			maxSecX := max(st.x1, st.x2) + float64(s.distance/2.0)
-			if st.section.x2 == 0 || st.section.x2 < maxSecX {
-				st.section.x2 = maxSecX
+			for i := *sec.firstStepIndex; i <= last; i++ {
+				idx, ok := newIndex[i]
+				if !ok {
+					continue
+				}
+				if nsec.firstStepIndex == nil {
+					nsec.firstStepIndex = &idx
+				}
+				if sec.lastStepIndex != nil {
+					nsec.lastStepIndex = &idx
+				}
 			}
-
-			sw := max(0, math.Abs(st.section.x-st.section.x2))
-			if st.section.width < sw {
-				st.section.width = sw
+			if nsec.firstStepIndex == nil {
+				// all its steps were filtered
+				continue
 			}
 		}
+		newSection[sec] = nsec
+		ns.sections = append(ns.sections, nsec)
 	}
-
-	// Draw sections
-	for _, sec := range s.sections {
-		if !s.verticalSectionText {
-			// Offset the sections to make space for horizontal labels
-			sec.height -= 4
-			sec.y += 2
-			sec.x2 -= 2
+	for _, st := range ns.steps {
+		if st.section != nil {
+			st.section = newSection[st.section]
 		}
+	}
 
-		var secText *text
-		if s.verticalSectionText {
-			secText = &text{X: sec.x, Y: sec.y - (float64(sec.height / 2.0)), Transform: fmt.Sprintf("rotate(180,%d,%d)", int(sec.x-4), int(sec.y)), Fill: sec.color, Stroke: "none", FontSize: "10", TextAnchor: "middle", WritingMode: "tb", Content: sec.name}
-		} else {
-			secText = &text{X: sec.x, Y: sec.y - 2, Fill: sec.color, Stroke: "none", FontSize: "10", TextAnchor: "start", Content: sec.name}
+	// re-index the activations to the steps kept
+	ns.activations = nil
+	for _, a := range s.activations {
+		na := activation{actor: a.actor, last: -1, depth: a.depth, open: a.open}
+		for i := range a.first {
+			if _, ok := newIndex[i]; ok {
+				na.first++
+			}
 		}
-		secElem := rect{X: sec.x, Y: sec.y, Height: float64(sec.height), Width: float64(sec.width), Fill: sec.color, FillOpacity: 0.1}
-		if sec.bordered {
-			secElem.Stroke = sec.color
-			secElem.StrokeWidth = 1
+		for i := range a.last + 1 {
+			if _, ok := newIndex[i]; ok {
+				na.last++
+			}
 		}
-		root.Elements = append(root.Elements, secElem, *secText)
+		ns.activations = append(ns.activations, na)
 	}
 
-	// Draw steps
-	var x2 float64
-	for _, st := range s.steps {
-		if st.x1 == st.x2 {
-			// dot
-			root.Elements = append(root.Elements,
-				circle{CX: st.x1, CY: st.y, R: 3, Fill: st.Color},
-			)
-		} else {
-			if st.x1 < st.x2 {
-				x2 = st.x2 - 5
-			} else {
-				x2 = st.x2 + 5
+	// re-index the suspensions to the steps kept, dropping the ones without steps,
+	// the invalid ones are kept as they are so generating reports them
+	ns.suspensions = nil
+	for name, ranges := range s.suspensions {
+		for _, r := range ranges {
+			if r[0] < 0 || r[1] < r[0] || r[1] >= len(s.steps) {
+				ns.SuspendActor(name, r[0], r[1])
+				continue
+			}
+			first, last := -1, -1
+			for i := r[0]; i <= r[1]; i++ {
+				if idx, ok := newIndex[i]; ok {
+					if first < 0 {
+						first = idx
+					}
+					last = idx
+				}
+			}
+			if first >= 0 {
+				ns.SuspendActor(name, first, last)
 			}
-			// arrow
-			root.Elements = append(root.Elements,
-				line{X1: st.x1, Y1: st.y, X2: x2, Y2: st.y, Fill: st.Color, Stroke: st.Color, StrokeWidth: 2, MarkerStart: "url(#seq-dot)", MarkerEnd: "url(#seq-arrow)"},
-			)
 		}
+	}
 
-		// description
-		if st.Text != "" {
-			parts := strings.Split(st.Text, "\n")
-			offset := float64(descriptionOffset)
-			for i := len(parts) - 1; i >= 0; i-- {
-				p := parts[i]
-				root.Elements = append(root.Elements,
-					text{Class: "seq-desc", X: float64(st.x1+st.x2) / 2, Y: st.y - offset, Fill: st.Color, Stroke: "none", FontSize: "10", TextAnchor: "middle", Content: p},
-				)
-				offset += descriptionOffset * descriptionOffsetFactor
-			}
+	// re-index the back references, dropping the ones whose steps were not kept,
+	// the invalid ones are kept as they are so generating reports them
+	ns.backReferences = nil
+	for _, r := range s.backReferences {
+		if r.to < 0 || r.from <= r.to || r.from >= len(s.steps) {
+			ns.backReferences = append(ns.backReferences, r)
+			continue
+		}
+		from, okFrom := newIndex[r.from]
+		to, okTo := newIndex[r.to]
+		if okFrom && okTo {
+			ns.backReferences = append(ns.backReferences, backReference{from: from, to: to, label: r.label})
 		}
 	}
 
+	return &ns
+}
+
+// Canonical returns a normalized text representation of the sequence,
+// independent of the layout, which is stable and suitable for diffing.
+//
+// It lists the actors in order, then the steps and then the sections with the range of steps they cover.
+func (s *Sequence) Canonical() string {
 	var sb strings.Builder
-	encoder := xml.NewEncoder(&sb)
-	encoder.Indent("", "  ")
-	if err := encoder.Encode(root); err != nil {
-		return "", err
+
+	sb.WriteString("actors:\n")
+	for _, a := range s.actors {
+		fmt.Fprintf(&sb, "  %q\n", a)
 	}
-	return sb.String(), nil
-}
 
-// getHeight returns the height of the step including the text description offset
-func (s *Sequence) getHeight(st *Step) int {
-	height := s.stepHeight
-	incr := len(strings.Split(st.Text, "\n")) - 1
-	height += int((descriptionOffset * descriptionOffsetFactor) * incr)
-	return height
+	sb.WriteString("steps:\n")
+	for i, st := range s.steps {
+		if st.note {
+			kind := "note"
+			if st.ref {
+				kind = "ref"
+			}
+			fmt.Fprintf(&sb, "  %d. %s %q over %q %s\n", i+1, kind, st.Text, st.noteActors, st.Color)
+			continue
+		}
+		if st.decision {
+			fmt.Fprintf(&sb, "  %d. decision %q on %q %s\n", i+1, st.Text, st.Source, st.Color)
+			continue
+		}
+		if st.timeBreak {
+			fmt.Fprintf(&sb, "  %d. time break on %q\n", i+1, st.Source)
+			continue
+		}
+		fmt.Fprintf(&sb, "  %d. %q -> %q %q %s\n", i+1, st.Source, st.Target, st.Text, st.Color)
+	}
+
+	sb.WriteString("sections:\n")
+	sections := slices.Clone(s.sections)
+	slices.SortStableFunc(sections, func(a, b *section) int {
+		return cmp.Compare(a.openIndex, b.openIndex)
+	})
+	for _, sec := range sections {
+		steps := "empty"
+		if sec.firstStepIndex != nil && sec.lastStepIndex != nil {
+			steps = fmt.Sprintf("%d-%d", *sec.firstStepIndex+1, *sec.lastStepIndex+1)
+		} else if sec.firstStepIndex != nil {
+			steps = fmt.Sprintf("%d-open", *sec.firstStepIndex+1)
+		}
+		fmt.Fprintf(&sb, "  %q %s steps %s\n", sec.name, sec.color, steps)
+	}
+
+	return sb.String()
 }
 
-// setup initializes the sequence
-func (s *Sequence) setup() error {
-	// Check that all steps defined the actors
-	for i, step := range s.steps {
-		if step.Source == "" || step.Target == "" {
-			return fmt.Errorf("step #%d defined an actor with an empty name", i+1)
+// Hash returns a stable hash of the content and options of the sequence,
+// which excludes the layout computed by Generate.
+//
+// Equal sequences return the same hash so it can be used to cache the generated SVG.
+func (s *Sequence) Hash() string {
+	h := sha256.New()
+
+	for _, name := range s.actors {
+		x, pinned := s.pinnedX[name]
+		fmt.Fprintf(h, "actor %q %d %q %v %g %q\n", name, s.actorsMap[name].style, s.actorsMap[name].group, pinned, x, s.actorColors[name])
+	}
+
+	for _, st := range s.steps {
+		// exclude the default colors
+		color, textColor := st.Color, st.TextColor
+		if st.autoColor {
+			color = ""
 		}
+		if st.autoTextColor {
+			textColor = ""
+		}
+		fmt.Fprintf(h, "step %q %q %q %q %q %q %v %v %q %q %q %g %v %q %d %d %d %g %q %q %v %v %q %v %v\n",
+			st.Text, st.Source, st.Target, color, textColor, st.SelfStyle, st.VerticalText, st.CreatesTarget,
+			st.Duration, st.DescriptionBelow, st.Timestamp, st.Opacity, st.NoArrow, st.DashPattern, st.Style, st.SpaceBefore, st.SpaceAfter, st.YOffset, st.Type, st.Layers, st.note, st.ref, st.noteActors, st.decision, st.timeBreak)
 	}
 
-	// Delete empty sections
-	fullSections := []*section{}
 	for _, sec := range s.sections {
+		first, last := -1, -1
 		if sec.firstStepIndex != nil {
-			fullSections = append(fullSections, sec)
+			first = *sec.firstStepIndex
+		}
+		if sec.lastStepIndex != nil {
+			last = *sec.lastStepIndex
 		}
+		fmt.Fprintf(h, "section %q %q %q %q %v %v %d %v %d %v %d %d\n",
+			sec.name, sec.color, sec.fillColor, sec.borderColor, sec.bordered, sec.dashed, sec.padding, sec.textDown, sec.openIndex, sec.closedEmpty, first, last)
 	}
-	s.sections = fullSections
 
-	// Check that all sections have been closed
-	for _, sec := range s.sections {
-		if sec.lastStepIndex == nil {
-			return fmt.Errorf("found open section: %s", sec.name)
+	var numbers []string
+	if s.autonumber {
+		for i := range s.steps {
+			numbers = append(numbers, s.formatNumber(i+1))
 		}
 	}
-
-	return nil
-}
-
-// totalWidth returns the total width of the SVG
-func (s *Sequence) totalWidth() int {
-	width := margin * 2
-	for range s.actorsMap {
-		width += s.distance
+	var viewBox any
+	if s.viewBox != nil {
+		viewBox = *s.viewBox
+	}
+	var colorSeed any
+	if s.colorSeed != nil {
+		colorSeed = *s.colorSeed
 	}
-	return width
+	fmt.Fprintf(h, "options %v\n", []any{
+		s.width, s.height, s.distance, s.stepHeight, s.selfLoopHeight, s.sectionMargin, s.adaptiveStepHeight, s.verticalSectionText, s.tightRepeatSpacing, s.offsetX, s.offsetY,
+		s.activeLayers, s.suspensions, s.backReferences, s.activations, s.badDeactivations, s.messageTypes, s.legend, s.groupDividers, s.hideUnusedActors, s.source, s.meta, s.maxActors, s.maxSteps, s.topPadding, s.noteStyle, s.alignment,
+		s.lineCap, s.omitDefs, s.markers, s.emptySectionPolicy, s.distanceFraction, s.colorByActor, colorSeed, s.defaultColor,
+		s.showTimestamps, s.preserveWhitespace, s.selfStyle, s.selfLabelSide, s.selfLabelOffset, s.labelClampToArrow, s.labelStyle, s.fontUnit, s.prefixActorInLabel, s.autonumber, numbers, s.lifelineTerminal, viewBox, s.title, s.descriptionItalic, s.maxDescriptionLines, s.timeDirection, s.autoLabelPlacement, s.xmlDeclaration, s.stylesheet,
+		s.noDashSnap, s.footer, s.watermark, s.watermarkColor, s.watermarkOpacity, s.noAnnotate, s.selectedAnnotate,
+	})
+
+	return hex.EncodeToString(h.Sum(nil))
 }
 
-// totalHeight returns the total height of the SVG
-func (s *Sequence) totalHeight() int {
-	height := actorFontSize + 2
+// Generate generates a new SVG sequence
+func (s *Sequence) Generate() (string, error) {
+	return s.GenerateContext(context.Background())
+}
+
+// GenerateContext generates a new SVG sequence, aborting with the context error
+// if the context is cancelled while drawing the steps.
+func (s *Sequence) GenerateContext(ctx context.Context) (string, error) {
+	var sb strings.Builder
+	if err := s.generateTo(ctx, &sb, nil); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// GenerateTo generates a new SVG sequence writing it to w.
+//
+// Use it to avoid holding the whole SVG in memory, e.g. when writing to a file.
+func (s *Sequence) GenerateTo(w io.Writer) error {
+	return s.generateTo(context.Background(), w, nil)
+}
+
+// GenerateWithLayout generates a new SVG sequence and returns it
+// with the coordinates computed to draw it.
+func (s *Sequence) GenerateWithLayout() (string, Layout, error) {
+	var sb strings.Builder
+	var layout Layout
+	if err := s.generateTo(context.Background(), &sb, &layout); err != nil {
+		return "", Layout{}, err
+	}
+	return sb.String(), layout, nil
+}
+
+// GenerateHTML generates a new SVG sequence wrapped in an HTML <figure>,
+// with the title as its <figcaption> if set, ready to be embedded in a page.
+//
+// The XML declaration and the stylesheet processing instruction are not written.
+func (s *Sequence) GenerateHTML() (string, error) {
+	ns := *s
+	ns.xmlDeclaration = false
+	ns.stylesheet = ""
+	svg, err := ns.Generate()
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	sb.WriteString("<figure>\n")
+	sb.WriteString(svg)
+	sb.WriteString("\n")
+	if s.title != "" {
+		fmt.Fprintf(&sb, "<figcaption>%s</figcaption>\n", html.EscapeString(s.title))
+	}
+	sb.WriteString("</figure>\n")
+	return sb.String(), nil
+}
+
+// GenerateOverview generates a scaled-down SVG of the sequence with only the lifelines
+// of the actors and a dot per step, e.g. to navigate tall diagrams as a minimap.
+//
+// The scale must be greater than zero, each dot has the id "seq-overview-step-N"
+// where N is the number of the step.
+func (s *Sequence) GenerateOverview(scale float64) (string, error) {
+	if scale <= 0 {
+		return "", fmt.Errorf("invalid overview scale: %g", scale)
+	}
+	var layout Layout
+	if err := s.generateTo(context.Background(), io.Discard, &layout); err != nil {
+		return "", err
+	}
+
+	width, height := layout.Width*scale, layout.Height*scale
+	root := svg{
+		Xmlns:               "http://www.w3.org/2000/svg",
+		Width:               fmt.Sprintf("%g", width),
+		Height:              fmt.Sprintf("%g", height),
+		ViewBox:             fmt.Sprintf("0 0 %g %g", width, height),
+		PreserveAspectRatio: "xMinYMin meet",
+		Elements: []any{
+			rect{X: 0, Y: 0, Width: width, Height: height, Fill: "#FFFFFF"},
+		},
+	}
+	for _, a := range layout.Actors {
+		root.Elements = append(root.Elements,
+			line{Class: s.annotation(AnnotateActors, "seq-overview-actor"), X1: a.X * scale, Y1: 0, X2: a.X * scale, Y2: height, Stroke: "#CCCCCC", StrokeWidth: 1},
+		)
+	}
+	for i, st := range layout.Steps {
+		root.Elements = append(root.Elements,
+			circle{ID: s.annotation(AnnotateSteps, fmt.Sprintf("seq-overview-step-%d", i+1)), Class: s.annotation(AnnotateSteps, "seq-overview-step"), CX: (st.X1 + st.X2) / 2 * scale, CY: st.Y * scale, R: 2, Fill: DefaultColor},
+		)
+	}
+
+	out, err := xml.MarshalIndent(root, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// Layout contains the coordinates of the generated sequence,
+// before applying the content offset.
+type Layout struct {
+	Width    float64         `json:"width"`
+	Height   float64         `json:"height"`
+	Actors   []ActorLayout   `json:"actors"`
+	Steps    []StepLayout    `json:"steps"`
+	Sections []SectionLayout `json:"sections"`
+}
+
+// ActorLayout contains the position of the lifeline of an actor
+type ActorLayout struct {
+	Name string  `json:"name"`
+	X    float64 `json:"x"`
+}
+
+// StepLayout contains the position of a step, from the lifeline of the source actor
+// to the lifeline of the target actor
+type StepLayout struct {
+	X1 float64 `json:"x1"`
+	X2 float64 `json:"x2"`
+	Y  float64 `json:"y"`
+}
+
+// SectionLayout contains the bounds of a section box
+type SectionLayout struct {
+	Name   string  `json:"name"`
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+// generateTo generates a new SVG sequence writing it to w,
+// filling the layout if it is not nil
+func (s *Sequence) generateTo(ctx context.Context, w io.Writer, layout *Layout) error {
+	// Generate only the steps of the active layers
+	if len(s.activeLayers) > 0 {
+		s = s.Filter(s.inActiveLayers)
+	}
+
+	if len(s.actors) == 0 {
+		return fmt.Errorf("sequence has no actors")
+	}
+	if len(s.steps) == 0 {
+		return fmt.Errorf("sequence has no steps")
+	}
+
+	// Lay out only the actors referenced by the steps, without removing them from the sequence
+	if s.hideUnusedActors {
+		s = s.withoutUnusedActors()
+	}
+
+	// Derive the distance from the width left by the margins and the timestamps column,
+	// without changing the configured one, and keep it when the width is too narrow
+	if s.distanceFraction > 0 {
+		width, err := strconv.ParseFloat(strings.TrimSuffix(s.width, "px"), 64)
+		if err == nil {
+			available := width - 2*margin - float64(s.timestampsWidth())
+			if d := int(s.distanceFraction * available / float64(len(s.actors))); d > 0 {
+				ns := *s
+				ns.distance = d
+				s = &ns
+			}
+		}
+	}
+
+	err := s.setup()
+	if err != nil {
+		return err
+	}
+
+	totalWidth := s.totalWidth()
+	totalHeight := s.totalHeight()
+	// the viewBox covers the content moved by the offset, also to the left or above the origin
+	viewX, viewY := min(0, s.offsetX), min(0, s.offsetY)
+	viewWidth := float64(totalWidth) + math.Abs(s.offsetX)
+	viewHeight := float64(totalHeight) + math.Abs(s.offsetY)
+	if s.viewBox != nil {
+		viewX, viewY, viewWidth, viewHeight = s.viewBox[0], s.viewBox[1], s.viewBox[2], s.viewBox[3]
+	}
+
+	root := svg{
+		Xmlns:               "http://www.w3.org/2000/svg",
+		Width:               s.width,
+		Height:              s.height,
+		ViewBox:             fmt.Sprintf("%g %g %g %g", viewX, viewY, viewWidth, viewHeight),
+		PreserveAspectRatio: s.preserveAspectRatio(),
+	}
+
+	// Title
+	if s.title != "" {
+		root.Elements = append(root.Elements, title{Content: s.title})
+	}
+
+	// Metadata
+	if s.source != "" || len(s.meta) > 0 {
+		md := metadata{Content: s.source}
+		for _, k := range slices.Sorted(maps.Keys(s.meta)) {
+			md.Elements = append(md.Elements, meta{Name: k, Content: s.meta[k]})
+		}
+		root.Elements = append(root.Elements, md)
+	}
+
+	// Definitions
+	if !s.omitDefs {
+		defs := svgDefs{
+			Elements: []any{
+				svgStyle{Content: defaultCSS},
+			},
+		}
+		async := func(t messageType) bool { return t.style == Async }
+		openArrow := slices.ContainsFunc(s.steps, func(st *Step) bool { return s.arrowStyle(st) == Async }) ||
+			(s.legendHeight() > 0 && slices.ContainsFunc(s.usedMessageTypes(), async))
+		if s.markers != "" {
+			defs.Raw = s.markers
+			// the custom markers were validated by SetMarkers
+			ids, _ := markerIDs(s.markers)
+			openArrow = openArrow && !ids["seq-arrow-open"]
+		} else {
+			defs.Elements = append(defs.Elements, defaultMarkers()...)
+		}
+		if openArrow {
+			defs.Elements = append(defs.Elements, openArrowMarker())
+		}
+		if slices.ContainsFunc(s.steps, func(st *Step) bool { return st.Duration != "" }) {
+			defs.Elements = append(defs.Elements, clockSymbol())
+		}
+		root.Elements = append(root.Elements, defs)
+	}
+
+	// Background
+	root.Elements = append(root.Elements,
+		rect{X: viewX, Y: viewY, Width: viewWidth, Height: viewHeight, Fill: "#FFFFFF"},
+	)
+	contentStart := len(root.Elements)
+
+	// Draw actors, below their lifelines when the time flows upward
+	headerY, lineY1, lineY2 := 0.0, float64(s.headerHeight()+dashArraySize), float64(totalHeight)
+	if s.legendHeight() > 0 {
+		// end the lifelines above the legend
+		lineY2 = float64(s.legendTop(totalHeight))
+	}
+	if s.timeDirection == "up" {
+		headerY, lineY1, lineY2 = float64(s.lifelineEnd()), 0, float64(s.lifelineEnd())
+	}
+	for _, name := range s.actors {
+		a := s.actorsMap[name]
+		x, class := a.x, "seq-actor-"+a.slug
+		nameColor := DefaultColor
+		if color, ok := s.actorColors[name]; ok {
+			nameColor = color
+		}
+
+		g := group{Class: s.annotation(AnnotateActors, "seq-actor")}
+		if createdY, ok := s.creationY(name); ok {
+			// the actor is created by a step, draw its box at that step
+			w := actorBoxWidth(name)
+			y1, y2 := createdY+actorBoxHeight/2, lineY2
+			if s.timeDirection == "up" {
+				y1, y2 = lineY1, createdY-actorBoxHeight/2
+			}
+			// Actor line
+			g.Elements = append(g.Elements, s.lifeline(name, class, x, y1, y2)...)
+			g.Elements = append(g.Elements,
+				// Actor box
+				rect{Class: s.annotation(AnnotateActors, "seq-created"), X: x - w/2, Y: createdY - actorBoxHeight/2, Width: w, Height: actorBoxHeight, Fill: "#FFFFFF", Stroke: DefaultColor, StrokeWidth: 1},
+				// Actor text
+				text{Class: s.annotation(AnnotateActors, class), X: x, Y: createdY + actorFontSize/2 - 2, FontSize: s.fontSize(actorFontSize), Stroke: "none", Fill: nameColor, TextAnchor: "middle", Content: name},
+			)
+		} else {
+			switch a.style {
+			case actorDatabase:
+				database := databaseShape(x, headerY+float64(s.topPadding+2))
+				database.Class = s.annotation(AnnotateActors, database.Class)
+				g.Elements = append(g.Elements, database)
+			case actorHuman:
+				human := humanShape(x, headerY+float64(s.topPadding+2))
+				human.Class = s.annotation(AnnotateActors, human.Class)
+				g.Elements = append(g.Elements, human)
+			case actorQueue:
+				queue := queueShape(x, headerY+float64(s.topPadding+2))
+				queue.Class = s.annotation(AnnotateActors, queue.Class)
+				g.Elements = append(g.Elements, queue)
+			}
+
+			// Actor line
+			g.Elements = append(g.Elements, s.lifeline(name, class, x, lineY1, lineY2)...)
+			g.Elements = append(g.Elements,
+				// Actor text
+				text{Class: s.annotation(AnnotateActors, class), X: x, Y: headerY + float64(s.headerHeight()), FontSize: s.fontSize(actorFontSize), Stroke: "none", Fill: nameColor, TextAnchor: "middle", Content: name},
+			)
+		}
+
+		// Lifeline terminal, inside the diagram at the end of the lifeline
+		endY := lineY2 - terminalSize
+		if s.timeDirection == "up" {
+			endY = lineY1 + terminalSize
+		}
+		g.Elements = append(g.Elements, s.terminalElements(x, endY)...)
+		root.Elements = append(root.Elements, g)
+	}
+
+	// Draw the dividers between groups of actors, behind the steps
+	if s.groupDividers {
+		for _, x := range s.groupBoundaries() {
+			root.Elements = append(root.Elements,
+				line{Class: s.annotation(AnnotateActors, "seq-group-divider"), X1: x, Y1: 0, X2: x, Y2: float64(totalHeight), Stroke: "#EEEEEE", StrokeWidth: 1},
+			)
+		}
+	}
+
+	// Compute steps and section values, the sections are shortened by the margin
+	// so the sections of adjacent steps do not overlap
+	for _, sec := range s.sections {
+		sec.x, sec.x2, sec.y, sec.width, sec.height = 0, 0, 0, 0, -s.sectionMargin
+	}
+	for i, st := range s.steps {
+		if i%ctxCheckInterval == 0 && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		source, target := st.Source, st.Target
+		if st.note {
+			// the span of the notes was checked by setup
+			source, target, _ = s.resolveNote(st)
+		}
+		st.x1 = s.actorsMap[source].x
+		st.x2 = s.actorsMap[target].x
+
+		if st.section != nil {
+			stHeight := st.height
+			st.section.height += stHeight
+
+			minSecY := max(0, st.y+float64(belowHeight(st)-stHeight)+float64(s.stepHeight)/2.0)
+			if st.section.y == 0 || st.section.y > minSecY {
+				st.section.y = minSecY
+			}
+
+			minSecX := max(1.0, min(st.x1, st.x2)-float64(s.distance/2.0))
+			if st.section.x == 0 || st.section.x > minSecX {
+				st.section.x = minSecX
+			}
+
+			maxSecX := max(st.x1, st.x2) + float64(s.distance/2.0)
+			if st.section.x2 == 0 || st.section.x2 < maxSecX {
+				st.section.x2 = maxSecX
+			}
+
+			sw := max(0, math.Abs(st.section.x-st.section.x2))
+			if st.section.width < sw {
+				st.section.width = sw
+			}
+		}
+	}
+
+	// Finish the section boxes: empty placeholders, padding and a minimum width
+	// so they are always visible, centered on their steps
+	for _, sec := range s.sections {
+		if sec.firstStepIndex == nil {
+			// placeholder for an empty section, between the steps where it was opened
+			prevY := float64(s.headerHeight())
+			if s.timeDirection == "up" {
+				// the step drawn above is the next one
+				prevY = 0
+				if sec.openIndex < len(s.steps) {
+					prevY = s.steps[sec.openIndex].y
+				}
+			} else if sec.openIndex > 0 {
+				prevY = s.steps[sec.openIndex-1].y
+			}
+			sec.x = margin
+			sec.y = prevY + float64(s.stepHeight)/2
+			sec.width = minSectionWidth
+			sec.height = emptySectionHeight
+			continue
+		}
+		sec.y -= float64(sec.padding)
+		sec.height += 2 * sec.padding
+
+		if sec.width < minSectionWidth {
+			center := sec.x + sec.width/2
+			sec.x = center - minSectionWidth/2
+			sec.x2 = center + minSectionWidth/2
+			sec.width = minSectionWidth
+		}
+	}
+
+	// Draw sections
+	var labels []bbox
+	for _, sec := range s.sections {
+		if !s.verticalSectionText {
+			// Offset the sections to make space for horizontal labels
+			sec.height -= 4
+			sec.y += 2
+			sec.x2 -= 2
+		}
+
+		vertical := s.verticalSectionText
+		if !vertical && s.autoLabelPlacement {
+			// switch the label to the vertical style if it overlaps a previous one
+			label := bbox{sec.x, sec.y - 12, sec.x + float64(utf8.RuneCountInString(sec.name)*descriptionCharWidth), sec.y - 2}
+			if slices.ContainsFunc(labels, label.overlaps) {
+				vertical = true
+			} else {
+				labels = append(labels, label)
+			}
+		}
+
+		var secText *text
+		if vertical && sec.textDown {
+			secText = &text{Class: s.selectedAnnotation(AnnotateSections, "seq-section-label"), X: sec.x - 8, Y: sec.y + float64(sec.height/2.0), Fill: sec.color, Stroke: "none", FontSize: s.fontSize(descriptionFontSize), TextAnchor: "middle", WritingMode: "tb", Content: sec.name}
+		} else if vertical {
+			secText = &text{Class: s.selectedAnnotation(AnnotateSections, "seq-section-label"), X: sec.x, Y: sec.y - (float64(sec.height / 2.0)), Transform: fmt.Sprintf("rotate(180,%d,%d)", int(sec.x-4), int(sec.y)), Fill: sec.color, Stroke: "none", FontSize: s.fontSize(descriptionFontSize), TextAnchor: "middle", WritingMode: "tb", Content: sec.name}
+		} else {
+			secText = &text{Class: s.selectedAnnotation(AnnotateSections, "seq-section-label"), X: sec.x, Y: sec.y - 2, Fill: sec.color, Stroke: "none", FontSize: s.fontSize(descriptionFontSize), TextAnchor: "start", Content: sec.name}
+		}
+		secElem := rect{Class: s.selectedAnnotation(AnnotateSections, "seq-section"), X: sec.x, Y: sec.y, Height: float64(sec.height), Width: float64(sec.width), Fill: sec.fillColor, FillOpacity: 0.1}
+		if sec.bordered {
+			secElem.Stroke = sec.borderColor
+			secElem.StrokeWidth = 1
+			if sec.dashed {
+				secElem.StrokeDasharray = fmt.Sprintf("%[1]d %[1]d", dashArraySize/2)
+			}
+		}
+		root.Elements = append(root.Elements, secElem, *secText)
+	}
+
+	// Draw the activation bars, above the lifelines and sections and below the steps
+	for _, a := range s.activations {
+		act, ok := s.actorsMap[a.actor]
+		if !ok || a.last < a.first {
+			continue
+		}
+		y1, y2 := s.steps[a.first].y, s.steps[a.last].y
+		top, bottom := min(y1, y2)-activationPadding, max(y1, y2)+activationPadding
+		root.Elements = append(root.Elements,
+			rect{Class: s.annotation(AnnotateActors, "seq-activation"), X: act.x - activationWidth/2 + float64(a.depth*activationWidth/2), Y: top, Width: activationWidth, Height: bottom - top, Fill: "#FFFFFF", Stroke: DefaultColor, StrokeWidth: 1},
+		)
+	}
+
+	// Draw steps
+	var x2 float64
+	for i, st := range s.steps {
+		if i%ctxCheckInterval == 0 && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if st.ref {
+			root.Elements = append(root.Elements, s.refElements(st)...)
+			continue
+		}
+		if st.note {
+			root.Elements = append(root.Elements, s.noteElements(st)...)
+			continue
+		}
+		if st.decision {
+			root.Elements = append(root.Elements, s.decisionElements(st)...)
+			continue
+		}
+		if st.timeBreak {
+			root.Elements = append(root.Elements, s.timeBreakElement(st))
+			continue
+		}
+
+		stepStart := len(root.Elements)
+		color, textColor := s.stepColor(st), s.stepTextColor(st)
+		dash := st.DashPattern
+		if dash == "" && s.arrowStyle(st) != Solid {
+			dash = fmt.Sprintf("%[1]d %[1]d", dashArraySize/2)
+		}
+		descX, descAnchor, descOffset := float64(st.x1+st.x2)/2, "middle", float64(descriptionOffset)
+		var tab *rect
+		// the arrowhead needs some space before the lifeline
+		markerStart, markerEnd, head := "url(#seq-dot)", "url(#seq-arrow)", 5.0
+		if s.arrowStyle(st) == Async {
+			markerEnd = "url(#seq-arrow-open)"
+		}
+		if st.NoArrow {
+			markerStart, markerEnd, head = "", "", 0
+		}
+		if s.isLoop(st) {
+			// loop
+			loopX := st.x1 + float64(s.distance)/4
+			loopY := st.y - float64(s.selfLoopHeight)
+			root.Elements = append(root.Elements,
+				line{Class: s.selectedAnnotation(AnnotateSteps, "seq-step"), X1: st.x1, Y1: loopY, X2: loopX, Y2: loopY, Fill: color, Stroke: color, StrokeWidth: 2, StrokeDasharray: dash, StrokeLinecap: s.lineCap, MarkerStart: markerStart},
+				line{Class: s.selectedAnnotation(AnnotateSteps, "seq-step"), X1: loopX, Y1: loopY, X2: loopX, Y2: st.y, Fill: color, Stroke: color, StrokeWidth: 2, StrokeDasharray: dash, StrokeLinecap: s.lineCap},
+				line{Class: s.selectedAnnotation(AnnotateSteps, "seq-step"), X1: loopX, Y1: st.y, X2: st.x1 + head, Y2: st.y, Fill: color, Stroke: color, StrokeWidth: 2, StrokeDasharray: dash, StrokeLinecap: s.lineCap, MarkerEnd: markerEnd},
+			)
+			// place the description at the right of the loop
+			descX, descAnchor, descOffset = loopX+5, "start", float64(s.selfLoopHeight/2-3)
+		} else if st.x1 == st.x2 {
+			// dot
+			root.Elements = append(root.Elements,
+				circle{Class: s.selectedAnnotation(AnnotateSteps, "seq-step"), CX: st.x1, CY: st.y, R: 3, Fill: color},
+			)
+			// place the description beside the dot
+			if s.selfLabelSide == "left" {
+				descX, descAnchor = st.x1-float64(s.selfLabelOffset), "end"
+			} else {
+				descX, descAnchor = st.x1+float64(s.selfLabelOffset), "start"
+			}
+		} else {
+			// land on the box of the created actor instead of its lifeline
+			var boxOffset float64
+			if st.CreatesTarget {
+				boxOffset = actorBoxWidth(st.Target) / 2
+			}
+			if st.x1 < st.x2 {
+				x2 = st.x2 - head - boxOffset
+			} else {
+				x2 = st.x2 + head + boxOffset
+			}
+			if s.labelStyle == "tab" && !st.VerticalText && s.stepText(st) != "" && s.labelWidth(st)+2*labelTabPadding < math.Abs(x2-st.x1) {
+				// box holding the description, centered on the arrow
+				parts, _ := s.textLines(st)
+				w := s.labelWidth(st) + 2*labelTabPadding
+				h := float64(len(parts) * descriptionOffset * descriptionOffsetFactor)
+				tab = &rect{Class: s.annotation(AnnotateSteps, "seq-label-tab"), X: descX - w/2, Y: st.y - h/2, Width: w, Height: h, Fill: "white", Stroke: color, StrokeWidth: 1}
+			} else if s.labelClampToArrow && !st.VerticalText && s.labelWidth(st) > math.Abs(x2-st.x1) {
+				// anchor the description at the source, along the arrow
+				if st.x1 < st.x2 {
+					descX, descAnchor = st.x1+5, "start"
+				} else {
+					descX, descAnchor = st.x1-5, "end"
+				}
+			}
+			// arrow
+			if tab != nil {
+				// split the arrow around the tab
+				gapStart, gapEnd := tab.X, tab.X+tab.Width
+				if st.x1 > st.x2 {
+					gapStart, gapEnd = gapEnd, gapStart
+				}
+				root.Elements = append(root.Elements,
+					line{Class: s.selectedAnnotation(AnnotateSteps, "seq-step"), X1: st.x1, Y1: st.y, X2: gapStart, Y2: st.y, Fill: color, Stroke: color, StrokeWidth: 2, StrokeDasharray: dash, StrokeLinecap: s.lineCap, MarkerStart: markerStart},
+					line{Class: s.selectedAnnotation(AnnotateSteps, "seq-step"), X1: gapEnd, Y1: st.y, X2: x2, Y2: st.y, Fill: color, Stroke: color, StrokeWidth: 2, StrokeDasharray: dash, StrokeLinecap: s.lineCap, MarkerEnd: markerEnd},
+					*tab,
+				)
+			} else {
+				root.Elements = append(root.Elements,
+					line{Class: s.selectedAnnotation(AnnotateSteps, "seq-step"), X1: st.x1, Y1: st.y, X2: x2, Y2: st.y, Fill: color, Stroke: color, StrokeWidth: 2, StrokeDasharray: dash, StrokeLinecap: s.lineCap, MarkerStart: markerStart, MarkerEnd: markerEnd},
+				)
+			}
+		}
+
+		// description
+		var desc []any
+		parts, truncated := s.textLines(st)
+		if s.stepText(st) != "" && st.VerticalText {
+			midX, y := float64(st.x1+st.x2)/2, st.y-descriptionOffset
+			for i, p := range parts {
+				x := midX - 3 - float64((len(parts)-1-i)*descriptionOffset*descriptionOffsetFactor)
+				desc = append(desc,
+					text{Class: s.annotation(AnnotateDescriptions, "seq-desc"), X: x, Y: y, Transform: fmt.Sprintf("rotate(-90,%g,%g)", x, y), Fill: textColor, Stroke: "none", FontSize: s.fontSize(descriptionFontSize), FontStyle: s.descriptionFontStyle(), TextAnchor: "start", XMLSpace: s.xmlSpace(), Content: p},
+				)
+			}
+		} else if tab != nil {
+			// one line after the other inside the tab, from the top
+			for i, p := range parts {
+				desc = append(desc,
+					text{Class: s.annotation(AnnotateDescriptions, "seq-desc"), X: descX, Y: tab.Y + float64((i+1)*descriptionOffset*descriptionOffsetFactor-3), Fill: textColor, Stroke: "none", FontSize: s.fontSize(descriptionFontSize), FontStyle: s.descriptionFontStyle(), TextAnchor: "middle", XMLSpace: s.xmlSpace(), Content: p},
+				)
+			}
+		} else if s.stepText(st) != "" {
+			offset := descOffset
+			for i := len(parts) - 1; i >= 0; i-- {
+				p := parts[i]
+				desc = append(desc,
+					text{Class: s.annotation(AnnotateDescriptions, "seq-desc"), X: descX, Y: st.y - offset, Fill: textColor, Stroke: "none", FontSize: s.fontSize(descriptionFontSize), FontStyle: s.descriptionFontStyle(), TextAnchor: descAnchor, XMLSpace: s.xmlSpace(), Content: p},
+				)
+				offset += descriptionOffset * descriptionOffsetFactor
+			}
+		}
+		if truncated {
+			// show the full description as a tooltip
+			root.Elements = append(root.Elements, group{Elements: append([]any{title{Content: s.stepText(st)}}, desc...)})
+		} else {
+			root.Elements = append(root.Elements, desc...)
+		}
+
+		// duration
+		if st.Duration != "" {
+			midX := float64(st.x1+st.x2) / 2
+			root.Elements = append(root.Elements,
+				use{Href: "#seq-clock", X: midX, Y: st.y + durationOffset - 3, Stroke: textColor},
+				text{Class: s.annotation(AnnotateDescriptions, "seq-desc"), X: midX + 7, Y: st.y + durationOffset, Fill: textColor, Stroke: "none", FontSize: s.fontSize(descriptionFontSize), FontStyle: s.descriptionFontStyle(), TextAnchor: "start", Content: st.Duration},
+			)
+		}
+
+		// timestamp
+		if s.showTimestamps && st.Timestamp != "" {
+			root.Elements = append(root.Elements,
+				text{Class: s.annotation(AnnotateSteps, "seq-timestamp"), X: float64(margin + s.timestampsWidth() - timestampPadding), Y: st.y + 3, Fill: "#666666", Stroke: "none", FontSize: s.fontSize(descriptionFontSize), TextAnchor: "end", Content: st.Timestamp},
+			)
+		}
+
+		// description below
+		if st.DescriptionBelow != "" {
+			offset := float64(descriptionOffset * descriptionOffsetFactor)
+			if st.Duration != "" {
+				offset += durationOffset
+			}
+			for _, p := range strings.Split(st.DescriptionBelow, "\n") {
+				root.Elements = append(root.Elements,
+					text{Class: s.annotation(AnnotateDescriptions, "seq-desc"), X: descX, Y: st.y + offset, Fill: textColor, Stroke: "none", FontSize: s.fontSize(descriptionFontSize), FontStyle: s.descriptionFontStyle(), TextAnchor: descAnchor, XMLSpace: s.xmlSpace(), Content: p},
+				)
+				offset += descriptionOffset * descriptionOffsetFactor
+			}
+		}
+
+		// fade the whole step
+		if st.Opacity > 0 && st.Opacity < 1 {
+			faded := group{Opacity: st.Opacity, Elements: root.Elements[stepStart:]}
+			root.Elements = append(root.Elements[:stepStart:stepStart], faded)
+		}
+	}
+
+	// Draw back references
+	for _, r := range s.backReferences {
+		root.Elements = append(root.Elements, s.backReferenceElements(r)...)
+	}
+
+	// Footer
+	if s.footer != "" {
+		root.Elements = append(root.Elements,
+			text{Class: "seq-footer", X: margin, Y: float64(totalHeight - footerHeight/2 + 3), Fill: "#666666", Stroke: "none", FontSize: s.fontSize(descriptionFontSize), TextAnchor: "start", Content: s.footer},
+		)
+	}
+
+	// Legend
+	if s.legendHeight() > 0 {
+		root.Elements = append(root.Elements, s.legendElement(float64(s.legendTop(totalHeight))))
+	}
+
+	// Watermark
+	if s.watermark != "" {
+		cx, cy := float64(totalWidth)/2, float64(totalHeight)/2
+		root.Elements = append(root.Elements,
+			text{Class: "seq-watermark", X: cx, Y: cy, Transform: fmt.Sprintf("rotate(-30,%g,%g)", cx, cy), Fill: s.watermarkColor, FillOpacity: &s.watermarkOpacity, Stroke: "none", FontSize: s.fontSize(watermarkFontSize), TextAnchor: "middle", Content: s.watermark},
+		)
+	}
+
+	// Post-process the elements
+	if s.elementHook != nil {
+		for i, el := range root.Elements {
+			root.Elements[i] = s.elementHook(el)
+		}
+	}
+
+	// Offset the content
+	if s.offsetX != 0 || s.offsetY != 0 {
+		content := group{Transform: fmt.Sprintf("translate(%g,%g)", s.offsetX, s.offsetY), Elements: root.Elements[contentStart:]}
+		root.Elements = append(root.Elements[:contentStart:contentStart], content)
+	}
+
+	if s.xmlDeclaration {
+		if _, err := io.WriteString(w, xml.Header); err != nil {
+			return err
+		}
+	}
+	if s.stylesheet != "" {
+		var href strings.Builder
+		if err := xml.EscapeText(&href, []byte(s.stylesheet)); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "<?xml-stylesheet type=\"text/css\" href=\"%s\"?>\n", href.String()); err != nil {
+			return err
+		}
+	}
+
+	if layout != nil {
+		*layout = Layout{Width: float64(totalWidth), Height: float64(totalHeight)}
+		for _, name := range s.actors {
+			layout.Actors = append(layout.Actors, ActorLayout{Name: name, X: s.actorsMap[name].x})
+		}
+		for _, st := range s.steps {
+			layout.Steps = append(layout.Steps, StepLayout{X1: st.x1, X2: st.x2, Y: st.y})
+		}
+		for _, sec := range s.sections {
+			layout.Sections = append(layout.Sections, SectionLayout{Name: sec.name, X: sec.x, Y: sec.y, Width: sec.width, Height: float64(sec.height)})
+		}
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	return encoder.Encode(root)
+}
+
+// noteElements returns the box and the text lines of a note
+func (s *Sequence) noteElements(st *Step) []any {
+	parts, _ := s.textLines(st)
+	color, textColor := s.stepColor(st), s.stepTextColor(st)
+	lineHeight := float64(descriptionOffset * descriptionOffsetFactor)
+	x := st.x1 - float64(s.distance)/4
+	width := st.x2 - st.x1 + float64(s.distance)/2
+	height := float64(len(parts))*lineHeight + notePadding
+	y := st.y + notePadding/2 - height
+
+	var elems []any
+	if s.noteStyle == "folded" {
+		f := float64(noteFold)
+		elems = append(elems,
+			path{Class: s.annotation(AnnotateSteps, "seq-note"), D: fmt.Sprintf("M %[1]g %[2]g L %[3]g %[2]g L %[4]g %[5]g L %[4]g %[6]g L %[1]g %[6]g z", x, y, x+width-f, x+width, y+f, y+height), Fill: "#FFFFEE", Stroke: color, StrokeWidth: 1},
+			path{D: fmt.Sprintf("M %[1]g %[2]g L %[1]g %[3]g L %[4]g %[3]g z", x+width-f, y, y+f, x+width), Fill: "#EEEEDD", Stroke: color, StrokeWidth: 1},
+		)
+	} else {
+		elems = append(elems,
+			rect{Class: s.annotation(AnnotateSteps, "seq-note"), X: x, Y: y, Width: width, Height: height, Fill: "#FFFFEE", Stroke: color, StrokeWidth: 1},
+		)
+	}
+	for i, p := range parts {
+		elems = append(elems,
+			text{Class: s.annotation(AnnotateDescriptions, "seq-desc"), X: (st.x1 + st.x2) / 2, Y: y + notePadding/2 + float64(i+1)*lineHeight - 3, Fill: textColor, Stroke: "none", FontSize: s.fontSize(descriptionFontSize), FontStyle: s.descriptionFontStyle(), TextAnchor: "middle", XMLSpace: s.xmlSpace(), Content: p},
+		)
+	}
+	return elems
+}
+
+// refElements returns the box, the "ref" tab and the centered label of a reference fragment
+func (s *Sequence) refElements(st *Step) []any {
+	parts, _ := s.textLines(st)
+	color, textColor := s.stepColor(st), s.stepTextColor(st)
+	lineHeight := float64(descriptionOffset * descriptionOffsetFactor)
+	x := st.x1 - float64(s.distance)/4
+	width := st.x2 - st.x1 + float64(s.distance)/2
+	height := float64(len(parts))*lineHeight + notePadding + refTabHeight
+	y := st.y + notePadding/2 - height
+
+	elems := []any{
+		rect{Class: s.annotation(AnnotateSteps, "seq-ref"), X: x, Y: y, Width: width, Height: height, Fill: "#FFFFFF", Stroke: color, StrokeWidth: 1},
+		path{D: fmt.Sprintf("M %[1]g %[2]g L %[3]g %[2]g L %[3]g %[4]g L %[5]g %[6]g L %[1]g %[6]g z", x, y, x+refTabWidth, y+refTabHeight-4, x+refTabWidth-4, y+refTabHeight), Fill: "#FFFFFF", Stroke: color, StrokeWidth: 1},
+		text{X: x + 6, Y: y + refTabHeight - 4, Fill: textColor, Stroke: "none", FontSize: s.fontSize(descriptionFontSize), TextAnchor: "start", Content: "ref"},
+	}
+	for i, p := range parts {
+		elems = append(elems,
+			text{Class: s.annotation(AnnotateDescriptions, "seq-desc"), X: (st.x1 + st.x2) / 2, Y: y + refTabHeight + notePadding/2 + float64(i+1)*lineHeight - 3, Fill: textColor, Stroke: "none", FontSize: s.fontSize(descriptionFontSize), FontStyle: s.descriptionFontStyle(), TextAnchor: "middle", XMLSpace: s.xmlSpace(), Content: p},
+		)
+	}
+	return elems
+}
+
+// defaultMarkers returns the built-in markers of the steps
+func defaultMarkers() []any {
+	return []any{
+		marker{
+			ID: "seq-dot", ViewBox: "0 0 10 10", MarkerWidth: 5, MarkerHeight: 5, RefX: 5, RefY: 5,
+			Elements: []any{
+				circle{CX: 5, CY: 5, R: 3, Fill: "context-fill"},
+			},
+		},
+
+		marker{
+			ID: "seq-arrow", ViewBox: "0 0 10 10", MarkerWidth: 5, MarkerHeight: 5, RefX: 5, RefY: 5, Orient: "auto-start-reverse",
+			Elements: []any{
+				path{D: "M 0 0 L 10 5 L 0 10 z", Fill: "context-fill"},
+			},
+		},
+	}
+}
+
+// openArrowMarker returns the stroke-only arrowhead of the async steps
+func openArrowMarker() marker {
+	return marker{
+		ID: "seq-arrow-open", ViewBox: "0 0 10 10", MarkerWidth: 5, MarkerHeight: 5, RefX: 5, RefY: 5, Orient: "auto-start-reverse",
+		Elements: []any{
+			path{D: "M 1 1 L 9 5 L 1 9", Fill: "none", Stroke: "context-stroke", StrokeWidth: 2},
+		},
+	}
+}
+
+// clockSymbol returns the clock icon drawn next to the step durations
+func clockSymbol() group {
+	return group{
+		ID: "seq-clock",
+		Elements: []any{
+			circle{CX: 0, CY: 0, R: 4, Fill: "none"},
+			line{X1: 0, Y1: 0, X2: 0, Y2: -2.5},
+			line{X1: 0, Y1: 0, X2: 2, Y2: 0},
+		},
+	}
+}
+
+// SharedDefs returns the <defs> element with the style, the built-in markers
+// and the clock icon shared by all the diagrams.
+//
+// Place it once in a hidden <svg> element of the page and generate the diagrams
+// with SetIncludeDefs(false), they reference the shared definitions by id
+// ("seq-dot", "seq-arrow", "seq-arrow-open" and "seq-clock") so custom markers must keep those ids.
+func SharedDefs() string {
+	defs := svgDefs{
+		Elements: append([]any{svgStyle{Content: defaultCSS}}, append(defaultMarkers(), openArrowMarker(), clockSymbol())...),
+	}
+	// the definitions are built-in and always encode successfully
+	out, _ := xml.MarshalIndent(defs, "", "  ")
+	return string(out)
+}
+
+// preserveAspectRatio returns the preserveAspectRatio attribute value for the alignment
+func (s *Sequence) preserveAspectRatio() string {
+	switch s.alignment {
+	case "center":
+		return "xMidYMid meet"
+	case "end":
+		return "xMaxYMax meet"
+	default:
+		return "xMinYMin meet"
+	}
+}
+
+// terminalElements returns the marker at the end of a lifeline centered at x and y
+func (s *Sequence) terminalElements(x, y float64) []any {
+	class := s.annotation(AnnotateActors, "seq-lifeline-terminal")
+	switch s.lifelineTerminal {
+	case "bar":
+		return []any{
+			line{Class: class, X1: x - 2*terminalSize, Y1: y, X2: x + 2*terminalSize, Y2: y, Stroke: DefaultColor, StrokeWidth: 2},
+		}
+	case "cross":
+		return []any{
+			line{Class: class, X1: x - terminalSize, Y1: y - terminalSize, X2: x + terminalSize, Y2: y + terminalSize, Stroke: DefaultColor, StrokeWidth: 2},
+			line{Class: class, X1: x - terminalSize, Y1: y + terminalSize, X2: x + terminalSize, Y2: y - terminalSize, Stroke: DefaultColor, StrokeWidth: 2},
+		}
+	default:
+		return nil
+	}
+}
+
+// lifeline returns the lines of the lifeline of the actor from y1 to y2,
+// leaving a gap where the actor is suspended
+func (s *Sequence) lifeline(name, class string, x, y1, y2 float64) []any {
+	var elems []any
+	for _, seg := range s.lifelineSegments(name, y1, y2) {
+		elems = append(elems,
+			line{Class: s.annotation(AnnotateActors, "seq-actor-line "+class), X1: x, Y1: seg[0], X2: x, Y2: seg[1], Stroke: "#CCCCCC", StrokeDasharray: fmt.Sprintf("%[1]d %[1]d", dashArraySize), StrokeWidth: 2},
+		)
+	}
+	return elems
+}
+
+// lifelineSegments returns the segments from y1 to y2 that are not inside
+// a suspension of the actor, which spans half a step height around its steps
+func (s *Sequence) lifelineSegments(name string, y1, y2 float64) [][2]float64 {
+	var gaps [][2]float64
+	for _, susp := range s.suspensions[name] {
+		from, to := s.steps[susp[0]].y, s.steps[susp[1]].y
+		half := float64(s.stepHeight) / 2
+		gaps = append(gaps, [2]float64{min(from, to) - half, max(from, to) + half})
+	}
+	slices.SortFunc(gaps, func(a, b [2]float64) int { return cmp.Compare(a[0], b[0]) })
+
+	var segments [][2]float64
+	y := y1
+	for _, gap := range gaps {
+		if gap[0] > y {
+			segments = append(segments, [2]float64{y, min(gap[0], y2)})
+		}
+		y = max(y, gap[1])
+		if y >= y2 {
+			return segments
+		}
+	}
+	return append(segments, [2]float64{y, y2})
+}
+
+// creationY returns the 'y' value of the first step creating the actor, if any
+func (s *Sequence) creationY(name string) (float64, bool) {
+	for _, st := range s.steps {
+		if st.CreatesTarget && st.isArrow() && st.Target == name && st.Source != name {
+			return st.y, true
+		}
+	}
+	return 0, false
+}
+
+// actorBoxWidth returns the estimated width of the box of a created actor
+func actorBoxWidth(name string) float64 {
+	return float64(utf8.RuneCountInString(name)*actorCharWidth + 2*actorBoxPadding)
+}
+
+// backReferenceElements returns the curved arrow and the label of a back reference
+func (s *Sequence) backReferenceElements(r backReference) []any {
+	from, to := s.steps[r.from], s.steps[r.to]
+	x, curve := from.x1-5, float64(s.distance)/4
+	elems := []any{
+		path{Class: s.annotation(AnnotateSteps, "seq-back-reference"), D: fmt.Sprintf("M %[1]g %[2]g C %[3]g %[2]g %[3]g %[4]g %[1]g %[4]g", x, from.y, x-curve, to.y), Fill: "none", Stroke: s.defaultColor, StrokeWidth: 2, MarkerEnd: "url(#seq-arrow)"},
+	}
+	if r.label != "" {
+		// the curve reaches three quarters of the way to its control points
+		elems = append(elems,
+			text{Class: s.annotation(AnnotateDescriptions, "seq-desc"), X: x - curve*3/4 - 4, Y: (from.y+to.y)/2 + 3, Fill: s.defaultColor, Stroke: "none", FontSize: s.fontSize(descriptionFontSize), FontStyle: s.descriptionFontStyle(), TextAnchor: "end", XMLSpace: s.xmlSpace(), Content: r.label},
+		)
+	}
+	return elems
+}
+
+// timeBreakElement returns the zig-zag band across the lifeline of a time break,
+// filled to hide the lifeline
+func (s *Sequence) timeBreakElement(st *Step) path {
+	x, y, w := st.x1, st.y, float64(timeBreakSize)
+	return path{
+		Class:       s.annotation(AnnotateSteps, "seq-time-break"),
+		D:           fmt.Sprintf("M %g %g L %g %g L %g %g L %g %g L %g %g L %g %g L %g %g L %g %g z", x-w, y-2, x-w/2, y-6, x+w/2, y+2, x+w, y-2, x+w, y+2, x+w/2, y+6, x-w/2, y-2, x-w, y+2),
+		Fill:        "#FFFFFF",
+		Stroke:      "#CCCCCC",
+		StrokeWidth: 2,
+	}
+}
+
+// decisionElements returns the diamond and the question of a decision node
+func (s *Sequence) decisionElements(st *Step) []any {
+	color, textColor := s.stepColor(st), s.stepTextColor(st)
+	x, y := st.x1, st.y-decisionSize
+	elems := []any{
+		path{Class: s.annotation(AnnotateSteps, "seq-decision"), D: fmt.Sprintf("M %[1]g %[2]g L %[3]g %[4]g L %[1]g %[5]g L %[6]g %[4]g z", x, y-decisionSize, x+decisionSize, y, y+decisionSize, x-decisionSize), Fill: "#FFFFFF", Stroke: color, StrokeWidth: 2},
+	}
+	parts, _ := s.textLines(st)
+	for i, p := range parts {
+		elems = append(elems,
+			text{Class: s.annotation(AnnotateDescriptions, "seq-desc"), X: x + decisionSize + 4, Y: y + 3 - float64((len(parts)-1-i)*descriptionOffset*descriptionOffsetFactor), Fill: textColor, Stroke: "none", FontSize: s.fontSize(descriptionFontSize), FontStyle: s.descriptionFontStyle(), TextAnchor: "start", XMLSpace: s.xmlSpace(), Content: p},
+		)
+	}
+	return elems
+}
+
+// databaseShape returns a database cylinder centered at x with its top at y
+func databaseShape(x, y float64) group {
+	rx := float64(databaseWidth) / 2
+	bottom := y + databaseHeight - databaseRY
+	return group{
+		Class: "seq-database",
+		Elements: []any{
+			path{
+				D:      fmt.Sprintf("M %[1]g %[2]g L %[1]g %[3]g A %[4]g %[5]d 0 0 0 %[6]g %[3]g L %[6]g %[2]g", x-rx, y+databaseRY, bottom, rx, databaseRY, x+rx),
+				Fill:   "#FFFFFF",
+				Stroke: DefaultColor,
+			},
+			ellipse{CX: x, CY: y + databaseRY, RX: rx, RY: databaseRY, Fill: "#FFFFFF", Stroke: DefaultColor},
+		},
+	}
+}
+
+// queueShape returns a queue capsule centered at x with its top at y
+func queueShape(x, y float64) group {
+	left, right, ry := x-queueWidth/2+queueRX, x+queueWidth/2-queueRX, float64(queueHeight)/2
+	return group{
+		Class: "seq-queue",
+		Elements: []any{
+			path{
+				D:      fmt.Sprintf("M %[1]g %[3]g L %[2]g %[3]g A %[5]d %[6]g 0 0 1 %[2]g %[4]g L %[1]g %[4]g A %[5]d %[6]g 0 0 1 %[1]g %[3]g Z", left, right, y, y+queueHeight, queueRX, ry),
+				Fill:   "#FFFFFF",
+				Stroke: DefaultColor,
+			},
+			ellipse{CX: right, CY: y + ry, RX: queueRX, RY: ry, Fill: "#FFFFFF", Stroke: DefaultColor},
+		},
+	}
+}
+
+// descriptionFontStyle returns the font-style attribute value of the descriptions
+func (s *Sequence) descriptionFontStyle() string {
+	if s.descriptionItalic {
+		return "italic"
+	}
+	return ""
+}
+
+// fontSize returns the font-size value of a font of px pixels in the font unit
+func (s *Sequence) fontSize(px int) string {
+	if s.fontUnit == "em" {
+		return strconv.FormatFloat(float64(px)/baseFontSize, 'g', -1, 64) + "em"
+	}
+	return strconv.Itoa(px)
+}
+
+// annotation returns the id or class of an element, or an empty string
+// if the elements of its kind are not annotated
+func (s *Sequence) annotation(flag AnnotateFlags, class string) string {
+	if s.noAnnotate&flag != 0 {
+		return ""
+	}
+	return class
+}
+
+// selectedAnnotation returns the class of an element that is only annotated when
+// the elements of its kind were selected with SetAnnotate, or an empty string
+func (s *Sequence) selectedAnnotation(flag AnnotateFlags, class string) string {
+	if s.selectedAnnotate&flag == 0 {
+		return ""
+	}
+	return class
+}
+
+// humanShape returns a stick figure centered at x with its top at y
+func humanShape(x, y float64) group {
+	return group{
+		Class: "seq-human",
+		Elements: []any{
+			circle{CX: x, CY: y + 5, R: 5, Fill: "#FFFFFF", Stroke: DefaultColor},
+			line{X1: x, Y1: y + 10, X2: x, Y2: y + 20, Stroke: DefaultColor},
+			line{X1: x - 8, Y1: y + 14, X2: x + 8, Y2: y + 14, Stroke: DefaultColor},
+			line{X1: x, Y1: y + 20, X2: x - 7, Y2: y + humanHeight, Stroke: DefaultColor},
+			line{X1: x, Y1: y + 20, X2: x + 7, Y2: y + humanHeight, Stroke: DefaultColor},
+		},
+	}
+}
+
+// headerHeight returns the baseline of the actor names, which is where the steps start
+func (s *Sequence) headerHeight() int {
+	iconHeight := 0
+	for _, a := range s.actorsMap {
+		switch a.style {
+		case actorDatabase:
+			iconHeight = max(iconHeight, databaseHeight)
+		case actorHuman:
+			iconHeight = max(iconHeight, humanHeight)
+		case actorQueue:
+			iconHeight = max(iconHeight, queueHeight)
+		}
+	}
+
+	height := s.topPadding + actorFontSize + 2
+	if iconHeight > 0 {
+		// leave room for the icon above the name
+		height += iconHeight + 4
+	}
+	return height
+}
+
+// getHeight returns the height of the step including the text description offset
+// and the extra space around it
+func (s *Sequence) getHeight(st *Step) int {
+	if st.timeBreak {
+		return timeBreakHeight + st.SpaceBefore + belowHeight(st)
+	}
+	height := s.stepHeight
+	if s.adaptiveStepHeight && s.stepText(st) == "" {
+		// there is no description above the line
+		height /= 2
+	}
+	if st.VerticalText {
+		// the longest line is drawn vertically above the arrow
+		longest := 0
+		parts, _ := s.textLines(st)
+		for _, p := range parts {
+			longest = max(longest, utf8.RuneCountInString(p))
+		}
+		height = max(height, longest*descriptionCharWidth+descriptionOffset*2)
+	} else {
+		parts, _ := s.textLines(st)
+		incr := len(parts) - 1
+		height += int((descriptionOffset * descriptionOffsetFactor) * incr)
+	}
+	if s.isLoop(st) {
+		// each loop reserves its own space above the line
+		height += s.selfLoopHeight
+	}
+	return height + st.SpaceBefore + belowHeight(st)
+}
+
+// belowHeight returns the height of the step below the line,
+// the description below it and the extra space after the step
+func belowHeight(st *Step) int {
+	if st.DescriptionBelow == "" || !st.isArrow() {
+		return st.SpaceAfter
+	}
+	height := st.SpaceAfter + (strings.Count(st.DescriptionBelow, "\n")+1)*descriptionOffset*descriptionOffsetFactor
+	if st.Duration != "" {
+		height += durationOffset
+	}
+	return height
+}
+
+// xmlSpace returns the xml:space attribute value of the descriptions
+func (s *Sequence) xmlSpace() string {
+	if s.preserveWhitespace {
+		return "preserve"
+	}
+	return ""
+}
+
+// stepText returns the description of the step, prefixed with the source actor
+// and the number of the arrow if enabled
+func (s *Sequence) stepText(st *Step) string {
+	text := st.Text
+	if !st.isArrow() {
+		return text
+	}
+	if s.prefixActorInLabel && text != "" {
+		text = st.Source + ": " + text
+	}
+	if s.autonumber {
+		if text == "" {
+			text = s.formatNumber(st.number)
+		} else {
+			text = s.formatNumber(st.number) + " " + text
+		}
+	}
+	return text
+}
+
+// formatNumber returns the label of the number of an arrow
+func (s *Sequence) formatNumber(n int) string {
+	if s.autonumberFormat != nil {
+		return s.autonumberFormat(n)
+	}
+	return strconv.Itoa(n)
+}
+
+// textLines returns the lines of the step text, truncated to the maximum number
+// of description lines, and whether they were truncated
+func (s *Sequence) textLines(st *Step) ([]string, bool) {
+	parts := strings.Split(s.stepText(st), "\n")
+	if s.maxDescriptionLines <= 0 || len(parts) <= s.maxDescriptionLines {
+		return parts, false
+	}
+	parts = slices.Clone(parts[:s.maxDescriptionLines])
+	parts[len(parts)-1] += "…"
+	return parts, true
+}
+
+// labelWidth returns the estimated width of the longest line of the step description
+func (s *Sequence) labelWidth(st *Step) float64 {
+	parts, _ := s.textLines(st)
+	longest := 0
+	for _, p := range parts {
+		longest = max(longest, utf8.RuneCountInString(p))
+	}
+	return float64(longest * descriptionCharWidth)
+}
+
+// validDashPattern returns true if the pattern is empty or a list
+// of non-negative numbers separated by spaces or commas
+func validDashPattern(pattern string) bool {
+	for _, f := range strings.FieldsFunc(pattern, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+		n, err := strconv.ParseFloat(f, 64)
+		if err != nil || n < 0 || math.IsInf(n, 0) || math.IsNaN(n) {
+			return false
+		}
+	}
+	return true
+}
+
+// isArrow returns true if the step is drawn as an arrow or self-message, not as a node
+func (st *Step) isArrow() bool {
+	return !st.note && !st.decision && !st.timeBreak
+}
+
+// isLoop returns true if the step is drawn as a self-message loop
+func (s *Sequence) isLoop(st *Step) bool {
+	style := st.SelfStyle
+	if style == "" {
+		style = s.selfStyle
+	}
+	return st.isArrow() && st.Source == st.Target && style == "loop"
+}
+
+// paddingBefore returns the space added above the step at index i
+// by the padding of the sections that start at it or end right above it
+func (s *Sequence) paddingBefore(i int) int {
+	padding := 0
+	for _, sec := range s.sections {
+		if sec.firstStepIndex == nil {
+			continue
+		}
+		first, last, prev := *sec.firstStepIndex, *sec.lastStepIndex, i-1
+		if s.timeDirection == "up" {
+			// the sections are drawn upside down
+			first, last, prev = last, first, i+1
+		}
+		if first == i {
+			padding += sec.padding
+		}
+		if last == prev {
+			padding += sec.padding
+		}
+	}
+	return padding
+}
+
+// keepEmptySections returns true if the sections without steps must not be deleted
+func (s *Sequence) keepEmptySections() bool {
+	return s.emptySectionPolicy == "error" || s.emptySectionPolicy == "keep"
+}
+
+// sameActors returns true if both steps are arrows between the same source and target actors
+func sameActors(a, b *Step) bool {
+	return a.isArrow() && b.isArrow() && a.Source == b.Source && a.Target == b.Target
+}
+
+// setup initializes the sequence
+func (s *Sequence) setup() error {
+	// Compute the 'x' value of each actor
+	if err := s.placeActors(); err != nil {
+		return err
+	}
+
+	// Name the CSS class of each actor
+	s.assignActorSlugs()
+
+	// Assign the palette colors of the actors
+	if s.colorByActor {
+		s.assignActorColors()
+	}
+
+	// Check the message types of the steps
+	for i, st := range s.steps {
+		if _, ok := s.messageType(st.Type); st.Type != "" && !ok {
+			return fmt.Errorf("step #%d references an unknown message type: %q", i+1, st.Type)
+		}
+	}
+
+	// Check the limits
+	if s.maxActors > 0 && len(s.actors) > s.maxActors {
+		return fmt.Errorf("sequence has %d actors, exceeding the limit of %d", len(s.actors), s.maxActors)
+	}
+	if s.maxSteps > 0 && len(s.steps) > s.maxSteps {
+		return fmt.Errorf("sequence has %d steps, exceeding the limit of %d", len(s.steps), s.maxSteps)
+	}
+
+	// Check that all steps defined the actors
+	for i, step := range s.steps {
+		if step.note {
+			if _, _, err := s.resolveNote(step); err != nil {
+				return fmt.Errorf("step #%d: %v", i+1, err)
+			}
+			continue
+		}
+		if step.Source == "" || step.Target == "" {
+			return fmt.Errorf("step #%d defined an actor with an empty name", i+1)
+		}
+		if !validDashPattern(step.DashPattern) {
+			return fmt.Errorf("step #%d defined an invalid dash pattern: %q", i+1, step.DashPattern)
+		}
+		if step.SpaceBefore < 0 || step.SpaceAfter < 0 {
+			return fmt.Errorf("step #%d defined a negative space", i+1)
+		}
+	}
+
+	// Delete empty sections
+	fullSections := []*section{}
+	for _, sec := range s.sections {
+		if sec.firstStepIndex == nil {
+			switch s.emptySectionPolicy {
+			case "error":
+				return fmt.Errorf("found empty section: %s", sec.name)
+			case "keep":
+				fullSections = append(fullSections, sec)
+			}
+			continue
+		}
+		fullSections = append(fullSections, sec)
+	}
+	s.sections = fullSections
+
+	// Check the suspensions of the actors
+	for name, ranges := range s.suspensions {
+		for _, r := range ranges {
+			if r[0] < 0 || r[1] < r[0] || r[1] >= len(s.steps) {
+				return fmt.Errorf("invalid suspension of actor %s: steps %d to %d", name, r[0], r[1])
+			}
+		}
+	}
+
+	// Check the back references
+	for _, r := range s.backReferences {
+		if r.to < 0 || r.from <= r.to || r.from >= len(s.steps) {
+			return fmt.Errorf("invalid back reference: steps %d to %d", r.from, r.to)
+		}
+	}
+
+	// Check the activations
+	if len(s.badDeactivations) > 0 {
+		return fmt.Errorf("actor %s deactivated without an activation", s.badDeactivations[0])
+	}
+	for _, a := range s.activations {
+		if a.open {
+			return fmt.Errorf("found open activation of actor %s", a.actor)
+		}
+	}
+
+	// Check that all sections have been closed
+	for _, sec := range s.sections {
+		if sec.firstStepIndex != nil && sec.lastStepIndex == nil {
+			return fmt.Errorf("found open section: %s", sec.name)
+		}
+	}
+
+	// Check that the sections are nested or disjoint
+	for i, a := range s.sections {
+		if a.firstStepIndex == nil {
+			continue
+		}
+		for _, b := range s.sections[i+1:] {
+			if b.firstStepIndex == nil {
+				continue
+			}
+			af, al, bf, bl := *a.firstStepIndex, *a.lastStepIndex, *b.firstStepIndex, *b.lastStepIndex
+			if (af < bf && bf <= al && al < bl) || (bf < af && af <= bl && bl < al) {
+				return fmt.Errorf("found interleaved sections: %s and %s", a.name, b.name)
+			}
+		}
+	}
+
+	// Number the arrows
+	number := 0
+	for _, st := range s.steps {
+		if st.isArrow() {
+			number++
+			st.number = number
+		}
+	}
+
+	// Compute the height and 'y' value of each step, from top to bottom
+	y := float64(s.headerHeight())
+	order := make([]int, len(s.steps))
+	for i := range order {
+		order[i] = i
+	}
+	if s.timeDirection == "up" {
+		y = 0
+		slices.Reverse(order)
+	}
+	for k, i := range order {
+		st := s.steps[i]
+		st.height = s.getHeight(st)
+		if s.tightRepeatSpacing > 0 && k > 0 && sameActors(s.steps[order[k-1]], st) {
+			st.height -= int(float64(s.stepHeight) * (1 - s.tightRepeatSpacing))
+		}
+		y += float64(st.height + s.paddingBefore(i))
+		// the description below the line is part of the step height
+		st.y = y - float64(belowHeight(st)) + st.YOffset
+	}
+
+	return nil
+}
+
+// timestampsWidth returns the width of the column reserved for the timestamps
+func (s *Sequence) timestampsWidth() int {
+	if !s.showTimestamps {
+		return 0
+	}
+	longest := 0
+	for _, st := range s.steps {
+		longest = max(longest, utf8.RuneCountInString(st.Timestamp))
+	}
+	return longest*descriptionCharWidth + timestampPadding
+}
+
+// groupBoundaries returns the 'x' value halfway between adjacent actors of different groups
+func (s *Sequence) groupBoundaries() []float64 {
+	var boundaries []float64
+	for i := 1; i < len(s.actors); i++ {
+		prev, next := s.actorsMap[s.actors[i-1]], s.actorsMap[s.actors[i]]
+		if prev.group != "" && next.group != "" && prev.group != next.group {
+			boundaries = append(boundaries, (prev.x+next.x)/2)
+		}
+	}
+	return boundaries
+}
+
+// placeActors computes the 'x' value of each actor honoring the pinned positions,
+// the actors before the first pinned one are spread from the margin and
+// the ones after the last pinned one are placed at the distance between actors
+func (s *Sequence) placeActors() error {
+	left := float64(margin + s.timestampsWidth())
+	last, lastX := -1, left+float64(s.distance/2-s.distance)
+	for i, name := range s.actors {
+		x, ok := s.pinnedX[name]
+		if !ok {
+			continue
+		}
+		if last >= 0 && x <= lastX {
+			return fmt.Errorf("actor %s is pinned at x=%g, before %s at x=%g", name, x, s.actors[last], lastX)
+		}
+		if last < 0 {
+			d := (x - left) / (float64(i) + 0.5)
+			for j := range i {
+				s.actorsMap[s.actors[j]].x = left + d/2 + float64(j)*d
+			}
+		} else {
+			d := (x - lastX) / float64(i-last)
+			for j := last + 1; j < i; j++ {
+				s.actorsMap[s.actors[j]].x = lastX + float64(j-last)*d
+			}
+		}
+		s.actorsMap[name].x = x
+		last, lastX = i, x
+	}
+	for j := last + 1; j < len(s.actors); j++ {
+		s.actorsMap[s.actors[j]].x = lastX + float64((j-last)*s.distance)
+	}
+	return nil
+}
+
+// assignActorSlugs names the actors with their lowercase name where the runs
+// of non alphanumeric characters are replaced by '-', suffixing an index
+// when the slug is already used by a previous actor
+func (s *Sequence) assignActorSlugs() {
+	used := make(map[string]bool)
+	for _, name := range s.actors {
+		slug := slugify(name)
+		unique := slug
+		for i := 2; used[unique]; i++ {
+			unique = slug + "-" + strconv.Itoa(i)
+		}
+		used[unique] = true
+		s.actorsMap[name].slug = unique
+	}
+}
+
+// slugify returns the lowercase name with the runs of non alphanumeric characters replaced by '-'
+func slugify(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	if b.Len() == 0 {
+		return "actor"
+	}
+	return b.String()
+}
+
+// assignActorColors assigns a palette color to each actor, used by the steps without a color
+func (s *Sequence) assignActorColors() {
+	order := make([]int, len(actorPalette))
+	for i := range order {
+		order[i] = i
+	}
+	if s.colorSeed != nil {
+		r := rand.New(rand.NewPCG(uint64(*s.colorSeed), 0))
+		r.Shuffle(len(order), func(i, j int) { order[i], order[j] = order[j], order[i] })
+	}
+
+	for i, name := range s.actors {
+		s.actorsMap[name].color = actorPalette[order[i%len(order)]]
+	}
+}
+
+// withoutUnusedActors returns a copy of the sequence without the actors that are not referenced by any step
+func (s *Sequence) withoutUnusedActors() *Sequence {
+	used := make(map[string]bool)
 	for _, st := range s.steps {
-		height += s.getHeight(st)
+		used[st.Source] = true
+		used[st.Target] = true
+		for _, a := range st.noteActors {
+			used[a] = true
+		}
+		// a note without actors spans all of them
+		if st.note && len(st.noteActors) == 0 {
+			return s
+		}
+	}
+
+	ns := *s
+	ns.actors = []string{}
+	ns.actorsMap = make(map[string]*actor)
+	for _, a := range s.actors {
+		if used[a] {
+			ns.actors = append(ns.actors, a)
+			ns.actorsMap[a] = s.actorsMap[a]
+		}
+	}
+	return &ns
+}
+
+// resolveNote returns the leftmost and rightmost actors spanned by a note,
+// or an error if none of its actors is in the sequence
+func (s *Sequence) resolveNote(st *Step) (source, target string, err error) {
+	actors := st.noteActors
+	if len(actors) == 0 {
+		actors = s.actors
+	}
+	first, last := len(s.actors), -1
+	for _, a := range actors {
+		idx := slices.Index(s.actors, a)
+		if idx < 0 {
+			continue
+		}
+		first = min(first, idx)
+		last = max(last, idx)
+	}
+	if last < 0 {
+		return "", "", fmt.Errorf("note spans no actors")
+	}
+	return s.actors[first], s.actors[last], nil
+}
+
+// totalWidth returns the total width of the SVG
+func (s *Sequence) totalWidth() int {
+	if len(s.actors) == 0 {
+		return margin * 2
 	}
+	// leave half the distance between actors at the right of the last one
+	last := s.actorsMap[s.actors[len(s.actors)-1]]
+	return int(math.Ceil(last.x+float64(s.distance)/2)) + margin
+}
+
+// lifelineEnd returns the bottom of the lifelines when the time flows upward,
+// the first step is drawn at the bottom and the actors below it
+func (s *Sequence) lifelineEnd() int {
+	first := s.steps[0]
+	height := int(first.y) + belowHeight(first) + s.paddingBefore(-1)
 	height += s.stepHeight / 2 // extra margin
-	// ensure the height fits the dash-array so the sequence looks better
+	return s.snapToDash(height)
+}
+
+// snapToDash rounds up the height so it fits the dash-array and the sequence looks better,
+// unless it is disabled
+func (s *Sequence) snapToDash(height int) int {
+	if s.noDashSnap {
+		return height
+	}
 	for height%dashArraySize != 0 {
 		height++
 	}
 	return height
 }
+
+// totalHeight returns the total height of the SVG
+func (s *Sequence) totalHeight() int {
+	if s.timeDirection == "up" {
+		height := s.lifelineEnd() + s.headerHeight() + dashArraySize + s.legendHeight()
+		if s.footer != "" {
+			height += footerHeight
+		}
+		return height
+	}
+	last := s.steps[len(s.steps)-1]
+	height := int(last.y) + belowHeight(last) + s.paddingBefore(len(s.steps))
+	height += s.stepHeight / 2 // extra margin
+	height += s.legendHeight()
+	if s.footer != "" {
+		height += footerHeight
+	}
+	return s.snapToDash(height)
+}
+
+// messageType returns the message type registered with the key
+func (s *Sequence) messageType(key string) (messageType, bool) {
+	i := slices.IndexFunc(s.messageTypes, func(t messageType) bool { return t.key == key })
+	if i < 0 {
+		return messageType{}, false
+	}
+	return s.messageTypes[i], true
+}
+
+// arrowStyle returns the style of the step, or the style of its message type if it has none
+func (s *Sequence) arrowStyle(st *Step) ArrowStyle {
+	if st.Type == "" || st.Style != Solid {
+		return st.Style
+	}
+	if t, ok := s.messageType(st.Type); ok {
+		return t.style
+	}
+	return st.Style
+}
+
+// stepColor returns the color of the step, or if it has none the color of its message type
+// or the palette color of its source actor
+func (s *Sequence) stepColor(st *Step) string {
+	if !st.autoColor {
+		return st.Color
+	}
+	return s.autoStepColor(st, st.Color)
+}
+
+// stepTextColor returns the description color of the step, or if it has none the color
+// of its message type or the palette color of its source actor
+func (s *Sequence) stepTextColor(st *Step) string {
+	if !st.autoTextColor {
+		return st.TextColor
+	}
+	return s.autoStepColor(st, st.TextColor)
+}
+
+// autoStepColor returns the color of the message type of the step, or the palette color
+// of its source actor, or def if none applies
+func (s *Sequence) autoStepColor(st *Step, def string) string {
+	if t, ok := s.messageType(st.Type); ok && t.color != "" {
+		return t.color
+	}
+	if a, ok := s.actorsMap[st.Source]; ok && s.colorByActor && st.isArrow() {
+		return a.color
+	}
+	return def
+}
+
+// usedMessageTypes returns the message types referenced by the steps, in the order they were registered
+func (s *Sequence) usedMessageTypes() []messageType {
+	var used []messageType
+	for _, t := range s.messageTypes {
+		if slices.ContainsFunc(s.steps, func(st *Step) bool { return st.isArrow() && st.Type == t.key }) {
+			used = append(used, t)
+		}
+	}
+	return used
+}
+
+// legendHeight returns the height reserved for the legend, zero if it is not drawn
+func (s *Sequence) legendHeight() int {
+	if !s.legend {
+		return 0
+	}
+	n := len(s.usedMessageTypes())
+	if n == 0 {
+		return 0
+	}
+	return n*legendRowHeight + legendRowHeight/2
+}
+
+// legendTop returns the 'y' value of the top of the legend, which is drawn above the footer
+func (s *Sequence) legendTop(totalHeight int) int {
+	top := totalHeight - s.legendHeight()
+	if s.footer != "" {
+		top -= footerHeight
+	}
+	return top
+}
+
+// legendElement returns the legend of the message types used by the steps with its top at y,
+// a sample arrow and the label of each type
+func (s *Sequence) legendElement(y float64) group {
+	g := group{Class: "seq-legend"}
+	for i, t := range s.usedMessageTypes() {
+		rowY := y + float64(i*legendRowHeight+legendRowHeight/2)
+		color := t.color
+		if color == "" {
+			color = s.defaultColor
+		}
+		dash, markerEnd := "", "url(#seq-arrow)"
+		if t.style != Solid {
+			dash = fmt.Sprintf("%[1]d %[1]d", dashArraySize/2)
+		}
+		if t.style == Async {
+			markerEnd = "url(#seq-arrow-open)"
+		}
+		g.Elements = append(g.Elements,
+			line{X1: margin, Y1: rowY, X2: margin + legendSampleWidth, Y2: rowY, Fill: color, Stroke: color, StrokeWidth: 2, StrokeDasharray: dash, MarkerEnd: markerEnd},
+			text{X: margin + legendSampleWidth + 8, Y: rowY + 3, Fill: s.defaultColor, Stroke: "none", FontSize: s.fontSize(descriptionFontSize), TextAnchor: "start", Content: t.label},
+		)
+	}
+	return g
+}
@@ -3,9 +3,17 @@
 package svgsequence_test
 
 import (
+	"context"
 	_ "embed"
+	"errors"
 	"fmt"
+	"maps"
+	"math"
 	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
 
 	svgsequence "github.com/aorith/svg-sequence"
@@ -14,6 +22,18 @@ import (
 //go:embed tests/test1.svg
 var test1 string
 
+//go:embed tests/vertical_up.svg
+var verticalUp string
+
+//go:embed tests/vertical_down.svg
+var verticalDown string
+
+//go:embed tests/time_down.svg
+var timeDown string
+
+//go:embed tests/time_up.svg
+var timeUp string
+
 func TestNewSequence(t *testing.T) {
 	s := svgsequence.NewSequence()
 	s.OpenSection("Data", &svgsequence.SectionConfig{Color: "#998800"})
@@ -44,3 +64,2770 @@ func TestNewSequence(t *testing.T) {
 		_ = os.WriteFile(wantFn, []byte(want), 0o644)
 	}
 }
+
+func TestDatabaseActor(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.AddDatabaseActor("DB")
+	s.AddStep(svgsequence.Step{Source: "App", Target: "DB", Text: "query"})
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`<g class="seq-database">`,
+		`<ellipse cx="110" cy="7" rx="18" ry="5" fill="#FFFFFF" stroke="#000000"></ellipse>`,
+		`<text class="seq-actor-db" x="110" y="52" fill="#000000" stroke="none" font-size="16" text-anchor="middle">DB</text>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("AddDatabaseActor() output does not contain %s", want)
+		}
+	}
+
+	s.SetAnnotate(svgsequence.AnnotateSteps)
+	got, err = s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(got, `class="seq-database"`) {
+		t.Errorf("AddDatabaseActor() output without the actors annotated contains the seq-database class")
+	}
+}
+
+func TestQueueActor(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.AddQueueActor("Queue")
+	s.AddStep(svgsequence.Step{Source: "App", Target: "Queue", Text: "publish"})
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`<g class="seq-queue">`,
+		// the capsule and its visible end are centered on the lifeline
+		`<path d="M 95 2 L 125 2 A 5 10 0 0 1 125 22 L 95 22 A 5 10 0 0 1 95 2 Z" fill="#FFFFFF" stroke="#000000"></path>`,
+		`<ellipse cx="125" cy="12" rx="5" ry="10" fill="#FFFFFF" stroke="#000000"></ellipse>`,
+		`<line class="seq-actor-line seq-actor-queue" x1="110" y1="50" x2="110"`,
+		// the header leaves room for the capsule above the name
+		`<text class="seq-actor-queue" x="110" y="42" fill="#000000" stroke="none" font-size="16" text-anchor="middle">Queue</text>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("AddQueueActor() output does not contain %s", want)
+		}
+	}
+
+	s.SetAnnotate(svgsequence.AnnotateSteps)
+	got, err = s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(got, `class="seq-queue"`) {
+		t.Errorf("AddQueueActor() output without the actors annotated contains the seq-queue class")
+	}
+}
+
+func TestStepTextColor(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "error", Color: "#FF0000", TextColor: "#999999"})
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(got, `stroke="#FF0000"`) {
+		t.Errorf("TextColor: arrow does not use the step color")
+	}
+	if !strings.Contains(got, `fill="#999999" stroke="none" font-size="10" text-anchor="middle">error</text>`) {
+		t.Errorf("TextColor: description does not use the text color")
+	}
+}
+
+func TestNoteOverAllActors(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+	s.AddNote("state changed")
+	s.AddStep(svgsequence.Step{Source: "B", Target: "C"})
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the note spans from the first (x=110) to the last actor (x=470), including C which is added later
+	want := `<rect class="seq-note" x="65" y="100" width="450" height="22"`
+	if !strings.Contains(got, want) {
+		t.Errorf("AddNote() output does not contain %s", want)
+	}
+}
+
+func TestNoteSpanNotStored(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+	s.AddNote("state changed")
+	s.AddStep(svgsequence.Step{Source: "B", Target: "C", Layers: []string{"detail"}})
+	if _, err := s.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	var sb strings.Builder
+	if err := s.WritePlantUML(&sb); err != nil {
+		t.Fatal(err)
+	}
+
+	// the span resolved by Generate and WritePlantUML does not make C a used actor
+	s.SetActiveLayers("other")
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(got, ">C</text>") {
+		t.Errorf("AddNote() span resolved by a previous Generate() keeps the filtered actor")
+	}
+	if want := `<rect class="seq-note" x="65" y="100" width="270" height="22"`; !strings.Contains(got, want) {
+		t.Errorf("AddNote() output does not contain %s", want)
+	}
+}
+
+func TestTightRepeatSpacing(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.SetTightRepeatSpacing(0.5)
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+	s.AddStep(svgsequence.Step{Source: "B", Target: "A"})
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the second step is half a step height below the first one, the third a full step height
+	for _, want := range []string{`y1="68"`, `y1="93"`, `y1="143"`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("SetTightRepeatSpacing() output does not contain %s", want)
+		}
+	}
+
+	// factors out of range are ignored
+	for _, f := range []float64{-1, 2, math.NaN()} {
+		s := svgsequence.NewSequence()
+		s.SetTightRepeatSpacing(f)
+		s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+		s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+		got, err := s.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := `y1="118"`; !strings.Contains(got, want) {
+			t.Errorf("SetTightRepeatSpacing(%g) output does not contain %s", f, want)
+		}
+	}
+}
+
+func TestStepDuration(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "request", Duration: "120ms"})
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`<g id="seq-clock">`,
+		`<use href="#seq-clock" x="200" y="79" stroke="#000000"></use>`,
+		`<text class="seq-desc" x="207" y="82" fill="#000000" stroke="none" font-size="10" text-anchor="start">120ms</text>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Duration: output does not contain %s", want)
+		}
+	}
+}
+
+func TestActorGroup(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := strings.Count(got, `<g class="seq-actor">`); n != 2 {
+		t.Errorf("expected 2 actor groups, got %d", n)
+	}
+}
+
+func TestContentOffset(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.SetContentOffset(30, 10)
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{`viewBox="0 0 430 106"`, `<g transform="translate(30,10)">`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("SetContentOffset() output does not contain %s", want)
+		}
+	}
+}
+
+func TestContentOffsetNegative(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.SetContentOffset(-30, -10)
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the viewBox starts at the offset so the content is not clipped
+	for _, want := range []string{
+		`viewBox="-30 -10 430 106"`,
+		`<rect x="-30" y="-10" width="430" height="106" fill="#FFFFFF"></rect>`,
+		`<g transform="translate(-30,-10)">`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("SetContentOffset() with a negative offset output does not contain %s", want)
+		}
+	}
+}
+
+func TestHideUnusedActors(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.SetHideUnusedActors(true)
+	s.AddActors("B", "Unused", "A")
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(got, "Unused") {
+		t.Errorf("SetHideUnusedActors() output contains the unused actor")
+	}
+	// the explicit order is kept for the remaining actors
+	for _, want := range []string{`viewBox="0 0 400 96"`, `x="110" y="18" fill="#000000" stroke="none" font-size="16" text-anchor="middle">B</text>`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("SetHideUnusedActors() output does not contain %s", want)
+		}
+	}
+}
+
+func TestHideUnusedActorsNoteOverAllActors(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.AddActors("A", "B")
+	s.AddNote("hello")
+	s.SetHideUnusedActors(true)
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the note spans all the actors, so none of them is unused
+	for _, want := range []string{">A</text>", ">B</text>", ">hello</text>"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("SetHideUnusedActors() output does not contain %s", want)
+		}
+	}
+}
+
+func TestHideUnusedActorsKeepsActors(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.AddActors("B", "Unused", "A")
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+	s.SetHideUnusedActors(true)
+	hash, canonical := s.Hash(), s.Canonical()
+	if _, err := s.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	if s.Hash() != hash || s.Canonical() != canonical {
+		t.Errorf("Generate() with SetHideUnusedActors() changed the sequence")
+	}
+
+	s.SetHideUnusedActors(false)
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got, ">Unused</text>") {
+		t.Errorf("SetHideUnusedActors(false) output does not contain the unused actor")
+	}
+}
+
+func TestSectionMinWidth(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.SetDistance(10)
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+	s.OpenSection("self", nil)
+	s.AddStep(svgsequence.Step{Source: "A", Target: "A"})
+	s.AddStep(svgsequence.Step{Source: "A", Target: "A"})
+	s.CloseSection()
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the section is centered on actor A (x=25)
+	want := `<rect x="15" y="95" width="20" height="86"`
+	if !strings.Contains(got, want) {
+		t.Errorf("section output does not contain %s", want)
+	}
+}
+
+func TestEmbedSource(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.SetEmbedSource("@step A, B, <x> & ]]> y")
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `<metadata><![CDATA[@step A, B, <x> & ]]]]><![CDATA[> y]]></metadata>`
+	if !strings.Contains(got, want) {
+		t.Errorf("SetEmbedSource() output does not contain %s", want)
+	}
+}
+
+func TestGenerateContextCancelled(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := s.GenerateContext(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("GenerateContext() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		maxActors, maxSteps int
+		wantErr             bool
+	}{
+		{0, 0, false},
+		{3, 2, false},
+		{2, 0, true},
+		{0, 1, true},
+	}
+	for _, tt := range tests {
+		s := svgsequence.NewSequence()
+		s.SetLimits(tt.maxActors, tt.maxSteps)
+		s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+		s.AddStep(svgsequence.Step{Source: "B", Target: "C"})
+		_, err := s.Generate()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("SetLimits(%d, %d) error = %v, wantErr %v", tt.maxActors, tt.maxSteps, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSectionBorderDashed(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.OpenSection("draft", &svgsequence.SectionConfig{BorderDashed: true})
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+	s.CloseSection()
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `stroke="#000000" stroke-width="1" stroke-dasharray="4 4"></rect>`
+	if !strings.Contains(got, want) {
+		t.Errorf("BorderDashed: output does not contain %s", want)
+	}
+
+	// solid borders are the default
+	s = svgsequence.NewSequence()
+	s.OpenSection("solid", nil)
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+	s.CloseSection()
+	got, err = s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want = `stroke="#000000" stroke-width="1"></rect>`
+	if !strings.Contains(got, want) {
+		t.Errorf("solid section output does not contain %s", want)
+	}
+}
+
+func TestTopPadding(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.SetTopPadding(6)
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`viewBox="0 0 400 104"`,
+		`<line class="seq-actor-line seq-actor-a" x1="110" y1="32" x2="110" y2="104"`,
+		`<text class="seq-actor-a" x="110" y="24" fill="#000000" stroke="none" font-size="16" text-anchor="middle">A</text>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("SetTopPadding() output does not contain %s", want)
+		}
+	}
+}
+
+func TestNoteStyle(t *testing.T) {
+	tests := []struct {
+		style string
+		want  []string
+	}{
+		{"rect", []string{`<rect class="seq-note" x="65" y="50" width="90" height="22"`}},
+		{"folded", []string{
+			`<path class="seq-note" d="M 65 50 L 147 50 L 155 58 L 155 72 L 65 72 z"`,
+			`<path d="M 147 50 L 147 58 L 155 58 z"`,
+		}},
+	}
+	for _, tt := range tests {
+		s := svgsequence.NewSequence()
+		s.SetNoteStyle(tt.style)
+		s.AddNote("note", "A")
+		s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+		got, err := s.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, want := range tt.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("SetNoteStyle(%q) output does not contain %s", tt.style, want)
+			}
+		}
+	}
+}
+
+func TestSelfStyle(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.AddStep(svgsequence.Step{Source: "A", Target: "A", Text: "dot"})
+	s.AddStep(svgsequence.Step{Source: "A", Target: "A", Text: "loop", SelfStyle: "loop"})
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`<circle cx="110" cy="68" r="3" fill="#000000"></circle>`,
+		`<line x1="110" y1="118" x2="155" y2="118" fill="#000000" stroke="#000000" stroke-width="2" marker-start="url(#seq-dot)"></line>`,
+		`<line x1="155" y1="133" x2="115" y2="133" fill="#000000" stroke="#000000" stroke-width="2" marker-end="url(#seq-arrow)"></line>`,
+		`<text class="seq-desc" x="160" y="129" fill="#000000" stroke="none" font-size="10" text-anchor="start">loop</text>`,
+		// the loop height is reserved before the next step
+		`<line x1="110" y1="183" x2="285" y2="183"`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("SelfStyle: output does not contain %s", want)
+		}
+	}
+}
+
+func TestSectionPadding(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.OpenSection("padded", &svgsequence.SectionConfig{Padding: 10})
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+	s.CloseSection()
+	s.AddStep(svgsequence.Step{Source: "B", Target: "A"})
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`viewBox="0 0 400 168"`,
+		`<rect x="20" y="45" width="360" height="56"`,
+		`<line x1="110" y1="78" x2="285" y2="78"`,
+		// the next step is pushed down by the padding
+		`<line x1="290" y1="138" x2="115" y2="138"`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Padding: output does not contain %s", want)
+		}
+	}
+}
+
+func TestSectionFillAndBorderColor(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  svgsequence.SectionConfig
+		want string
+	}{
+		{"distinct", svgsequence.SectionConfig{Color: "red", FillColor: "yellow", BorderColor: "blue"}, `fill="yellow" fill-opacity="0.1" stroke="blue"`},
+		{"fill only", svgsequence.SectionConfig{Color: "red", FillColor: "yellow"}, `fill="yellow" fill-opacity="0.1" stroke="red"`},
+		{"border only", svgsequence.SectionConfig{Color: "red", BorderColor: "blue"}, `fill="red" fill-opacity="0.1" stroke="blue"`},
+		{"color", svgsequence.SectionConfig{Color: "red"}, `fill="red" fill-opacity="0.1" stroke="red"`},
+	}
+	for _, tt := range tests {
+		s := svgsequence.NewSequence()
+		s.OpenSection("colored", &tt.cfg)
+		s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+		s.CloseSection()
+		got, err := s.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(got, tt.want) {
+			t.Errorf("SectionConfig %s: output does not contain %s", tt.name, tt.want)
+		}
+		// the label keeps the section color
+		if label := `fill="red" stroke="none" font-size="10" text-anchor="start">colored</text>`; !strings.Contains(got, label) {
+			t.Errorf("SectionConfig %s: output does not contain %s", tt.name, label)
+		}
+	}
+}
+
+func TestActorIndex(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+	s.AddActors("C")
+
+	if got := s.Actors(); !slices.Equal(got, []string{"C", "A", "B"}) {
+		t.Errorf("Actors() = %v", got)
+	}
+	if idx, ok := s.ActorIndex("B"); idx != 2 || !ok {
+		t.Errorf(`ActorIndex("B") = %d, %v, want 2, true`, idx, ok)
+	}
+	if idx, ok := s.ActorIndex("D"); idx != -1 || ok {
+		t.Errorf(`ActorIndex("D") = %d, %v, want -1, false`, idx, ok)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.SetDistance(100)
+	s.OpenSection("ok", nil)
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "request"})
+	s.AddStep(svgsequence.Step{Source: "B", Target: "A", Text: "200 OK"})
+	s.CloseSection()
+	s.OpenSection("errors", nil)
+	s.AddStep(svgsequence.Step{Source: "A", Target: "C", Text: "request"})
+	s.AddStep(svgsequence.Step{Source: "C", Target: "A", Text: "500 error", Color: "#FF0000"})
+	s.CloseSection()
+
+	f := s.Filter(func(st svgsequence.Step) bool { return st.Color == "#FF0000" })
+	if got := f.Actors(); !slices.Equal(got, []string{"A", "C"}) {
+		t.Errorf("Filter() actors = %v", got)
+	}
+
+	got, err := f.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`viewBox="0 0 240 96"`, ">errors</text>", ">500 error</text>"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Filter() output does not contain %s", want)
+		}
+	}
+	for _, unwanted := range []string{">ok</text>", ">request</text>"} {
+		if strings.Contains(got, unwanted) {
+			t.Errorf("Filter() output contains %s", unwanted)
+		}
+	}
+
+	// the original sequence is untouched
+	if _, err := s.Generate(); err != nil {
+		t.Errorf("Generate() error after Filter(): %v", err)
+	}
+}
+
+func TestFilterDoesNotShareConfiguration(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.SetMeta("author", "Jane")
+	s.SetActorColor("A", "red")
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "request"})
+	want, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := s.Hash()
+
+	f := s.Filter(func(svgsequence.Step) bool { return true })
+	f.SetMeta("author", "John")
+	f.SetMeta("version", "2")
+	f.SetActorColor("A", "blue")
+	f.SetActorColor("B", "green")
+	f.SetActorX("A", 50)
+	f.RegisterMessageType("http", "red", svgsequence.Solid, "HTTP")
+
+	if got := s.Meta(); !maps.Equal(got, map[string]string{"author": "Jane"}) {
+		t.Errorf("Meta() of the original sequence = %v after changing the filtered copy", got)
+	}
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want || s.Hash() != hash {
+		t.Errorf("changing the filtered copy changed the original sequence")
+	}
+}
+
+func TestAlignment(t *testing.T) {
+	tests := []struct {
+		alignment string
+		want      string
+	}{
+		{"", `preserveAspectRatio="xMinYMin meet"`},
+		{"start", `preserveAspectRatio="xMinYMin meet"`},
+		{"center", `preserveAspectRatio="xMidYMid meet"`},
+		{"end", `preserveAspectRatio="xMaxYMax meet"`},
+	}
+	for _, tt := range tests {
+		s := svgsequence.NewSequence()
+		s.SetAlignment(tt.alignment)
+		s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+		got, err := s.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.Contains(got, tt.want) {
+			t.Errorf("SetAlignment(%q) output does not contain %s", tt.alignment, tt.want)
+		}
+	}
+}
+
+func TestHumanActor(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.AddHumanActor("User")
+	s.AddStep(svgsequence.Step{Source: "User", Target: "App", Text: "login"})
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`<g class="seq-human">`,
+		`<circle cx="110" cy="7" r="5" fill="#FFFFFF" stroke="#000000"></circle>`,
+		`<line x1="110" y1="22" x2="103" y2="32" stroke="#000000"></line>`,
+		`<text class="seq-actor-user" x="110" y="52" fill="#000000" stroke="none" font-size="16" text-anchor="middle">User</text>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("AddHumanActor() output does not contain %s", want)
+		}
+	}
+}
+
+func TestSetMarkers(t *testing.T) {
+	s := svgsequence.NewSequence()
+	if err := s.SetMarkers(`<marker id="seq-arrow"></marker>`); err == nil {
+		t.Errorf("SetMarkers() without seq-dot should return an error")
+	}
+	if err := s.SetMarkers(`<marker id="seq-dot">`); err == nil {
+		t.Errorf("SetMarkers() with invalid XML should return an error")
+	}
+
+	markers := `<marker id="seq-dot"><circle r="2"></circle></marker><marker id="seq-arrow"><path d="M 0 0 L 5 5"></path></marker>`
+	if err := s.SetMarkers(markers); err != nil {
+		t.Fatal(err)
+	}
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(got, markers) {
+		t.Errorf("SetMarkers() output does not contain the custom markers")
+	}
+	if strings.Count(got, `id="seq-arrow"`) != 1 {
+		t.Errorf("SetMarkers() output contains the built-in markers")
+	}
+
+	// the async steps use the built-in open arrow unless the custom markers define it
+	s.AddStep(svgsequence.Step{Source: "B", Target: "A", Style: svgsequence.Async})
+	got, err = s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got, `<marker id="seq-arrow-open"`) {
+		t.Errorf("SetMarkers() output with an async step does not define seq-arrow-open")
+	}
+
+	open := `<marker id="seq-arrow-open"><path d="M 0 0 L 5 5"></path></marker>`
+	if err := s.SetMarkers(markers + open); err != nil {
+		t.Fatal(err)
+	}
+	got, err = s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got, open) || strings.Count(got, `id="seq-arrow-open"`) != 1 {
+		t.Errorf("SetMarkers() output does not use the custom seq-arrow-open only")
+	}
+}
+
+func TestVerticalText(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.SetDistance(40)
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "a long description", VerticalText: true})
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 18 characters * 6px + 14px of offsets
+	for _, want := range []string{
+		`viewBox="0 0 120 168"`,
+		`<text class="seq-desc" x="57" y="133" fill="#000000" stroke="none" font-size="10" text-anchor="start" transform="rotate(-90,57,133)">a long description</text>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("VerticalText: output does not contain %s", want)
+		}
+	}
+}
+
+func TestEmptySectionPolicy(t *testing.T) {
+	tests := []struct {
+		policy  string
+		wantErr bool
+		want    bool // the empty section is drawn
+	}{
+		{"", false, false},
+		{"drop", false, false},
+		{"error", true, false},
+		{"keep", false, true},
+	}
+	for _, tt := range tests {
+		s := svgsequence.NewSequence()
+		s.SetEmptySectionPolicy(tt.policy)
+		s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+		s.OpenSection("empty", nil)
+		s.CloseAllSections()
+		s.AddStep(svgsequence.Step{Source: "B", Target: "A"})
+		got, err := s.Generate()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("SetEmptySectionPolicy(%q) error = %v, wantErr %v", tt.policy, err, tt.wantErr)
+		}
+		if err != nil && !strings.Contains(err.Error(), "empty") {
+			t.Errorf("SetEmptySectionPolicy(%q) error does not name the section: %v", tt.policy, err)
+		}
+
+		placeholder := `<rect x="20" y="95" width="20" height="8"`
+		if strings.Contains(got, placeholder) != tt.want {
+			t.Errorf("SetEmptySectionPolicy(%q) placeholder drawn = %v, want %v", tt.policy, !tt.want, tt.want)
+		}
+	}
+}
+
+func TestCreatesTarget(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "new", CreatesTarget: true})
+	s.AddStep(svgsequence.Step{Source: "B", Target: "A"})
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		// the box of B is centered on the first step
+		`<line class="seq-actor-line seq-actor-b" x1="290" y1="80" x2="290" y2="144"`,
+		`<rect class="seq-created" x="279.5" y="56" width="21" height="24"`,
+		`<text class="seq-actor-b" x="290" y="74" fill="#000000" stroke="none" font-size="16" text-anchor="middle">B</text>`,
+		// the arrow lands on the box
+		`<line x1="110" y1="68" x2="274.5" y2="68"`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("CreatesTarget: output does not contain %s", want)
+		}
+	}
+}
+
+func TestFooterAndWatermark(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.SetFooter("generated on 2026-01-01")
+	s.SetWatermark("DRAFT", &svgsequence.WatermarkConfig{Color: "#FF0000"})
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`viewBox="0 0 400 120"`,
+		`<text class="seq-footer" x="20" y="113" fill="#666666" stroke="none" font-size="10" text-anchor="start">generated on 2026-01-01</text>`,
+		`<text class="seq-watermark" x="200" y="60" fill="#FF0000" fill-opacity="0.3" stroke="none" font-size="64" text-anchor="middle" transform="rotate(-30,200,60)">DRAFT</text>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("footer and watermark output does not contain %s", want)
+		}
+	}
+
+	// an explicit opacity of zero is kept
+	opacity := 0.0
+	s.SetWatermark("DRAFT", &svgsequence.WatermarkConfig{Opacity: &opacity})
+	got, err = s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `<text class="seq-watermark" x="200" y="60" fill="#CCCCCC" fill-opacity="0" stroke="none"`; !strings.Contains(got, want) {
+		t.Errorf("watermark with zero opacity output does not contain %s", want)
+	}
+}
+
+func TestSectionVerticalDirection(t *testing.T) {
+	tests := []struct {
+		direction string
+		want      string
+	}{
+		{"up", verticalUp},
+		{"down", verticalDown},
+	}
+	for _, tt := range tests {
+		s := svgsequence.NewSequence()
+		s.SetVerticalSectionText(true)
+		s.OpenSection("Section", &svgsequence.SectionConfig{VerticalDirection: tt.direction})
+		s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "request"})
+		s.AddStep(svgsequence.Step{Source: "B", Target: "A", Text: "response"})
+		s.CloseSection()
+		got, err := s.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != tt.want {
+			gotFn := "got_vertical_" + tt.direction + ".svg"
+			wantFn := "want_vertical_" + tt.direction + ".svg"
+			t.Errorf(`VerticalDirection %q failed, resulting svg files saved as "%s" and "%s"`, tt.direction, gotFn, wantFn)
+			_ = os.WriteFile(gotFn, []byte(got), 0o644)
+			_ = os.WriteFile(wantFn, []byte(tt.want), 0o644)
+		}
+	}
+}
+
+func TestDefaultColor(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.SetDefaultColor("#333333")
+	s.OpenSection("section", nil)
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "default"})
+	s.AddStep(svgsequence.Step{Source: "B", Target: "A", Text: "custom", Color: "#FF0000"})
+	s.CloseSection()
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`fill="#333333" fill-opacity="0.1" stroke="#333333"`,
+		`fill="#333333" stroke="none" font-size="10" text-anchor="middle">default</text>`,
+		`fill="#FF0000" stroke="none" font-size="10" text-anchor="middle">custom</text>`,
+		// actor names keep the package default
+		`fill="` + svgsequence.DefaultColor + `" stroke="none" font-size="16" text-anchor="middle">A</text>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("SetDefaultColor() output does not contain %s", want)
+		}
+	}
+}
+
+func TestDistanceFraction(t *testing.T) {
+	tests := []struct {
+		width string
+		want  string
+	}{
+		{"640px", `viewBox="0 0 640 96"`},
+		{"640", `viewBox="0 0 640 96"`},
+		{"100%", `viewBox="0 0 400 96"`}, // fixed distance
+		{"30", `viewBox="0 0 400 96"`},   // too narrow, fixed distance
+	}
+	for _, tt := range tests {
+		s := svgsequence.NewSequence()
+		s.SetWidth(tt.width)
+		s.SetDistanceFraction(1)
+		s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+		got, err := s.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.Contains(got, tt.want) {
+			t.Errorf("SetDistanceFraction() with width %q output does not contain %s", tt.width, tt.want)
+		}
+	}
+
+	// the derived distance does not replace the configured one
+	s := svgsequence.NewSequence()
+	s.SetWidth("640")
+	s.SetDistanceFraction(1)
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+	hash := s.Hash()
+	if _, err := s.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	if s.Hash() != hash {
+		t.Errorf("Generate() with SetDistanceFraction() changed the sequence")
+	}
+	s.SetDistanceFraction(0)
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `viewBox="0 0 400 96"`; !strings.Contains(got, want) {
+		t.Errorf("SetDistanceFraction(0) after Generate() output does not contain %s", want)
+	}
+
+	// the timestamps column is not shared by the actors
+	s = svgsequence.NewSequence()
+	s.SetWidth("640")
+	s.SetDistanceFraction(1)
+	s.SetShowTimestamps(true)
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B", Timestamp: "10:00:00"})
+	got, err = s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `viewBox="0 0 640 96"`; !strings.Contains(got, want) {
+		t.Errorf("SetDistanceFraction() with timestamps output does not contain %s", want)
+	}
+
+	// negative and NaN fractions are ignored
+	for _, f := range []float64{-1, math.NaN()} {
+		s := svgsequence.NewSequence()
+		s.SetWidth("640")
+		s.SetDistanceFraction(f)
+		s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+		got, err := s.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := `viewBox="0 0 400 96"`; !strings.Contains(got, want) {
+			t.Errorf("SetDistanceFraction(%g) output does not contain %s", f, want)
+		}
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	build := func() *svgsequence.Sequence {
+		s := svgsequence.NewSequence()
+		s.AddActors("Client", "Server")
+		s.OpenSection("request", nil)
+		s.AddStep(svgsequence.Step{Source: "Client", Target: "Server", Text: "GET /"})
+		s.AddNote("processing", "Server")
+		s.CloseSection()
+		s.AddStep(svgsequence.Step{Source: "Server", Target: "Client", Text: "200 OK", Color: "#00AA00"})
+		return s
+	}
+
+	want := `actors:
+  "Client"
+  "Server"
+steps:
+  1. "Client" -> "Server" "GET /" #000000
+  2. note "processing" over ["Server"] #000000
+  3. "Server" -> "Client" "200 OK" #00AA00
+sections:
+  "request" #000000 steps 1-2
+`
+	s := build()
+	if got := s.Canonical(); got != want {
+		t.Errorf("Canonical() =\n%s\nwant:\n%s", got, want)
+	}
+
+	// generating the sequence does not change its canonical form
+	if _, err := s.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Canonical(); got != build().Canonical() {
+		t.Errorf("Canonical() changed after Generate():\n%s", got)
+	}
+}
+
+func TestColorSeed(t *testing.T) {
+	colors := func(seed int64) []string {
+		s := svgsequence.NewSequence()
+		s.SetColorByActor(true)
+		s.SetColorSeed(seed)
+		s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+		s.AddStep(svgsequence.Step{Source: "B", Target: "C"})
+		s.AddStep(svgsequence.Step{Source: "C", Target: "A", Color: "#123456"})
+		got, err := s.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var strokes []string
+		for _, part := range strings.Split(got, `stroke="`)[1:] {
+			if c, _, _ := strings.Cut(part, `"`); strings.HasPrefix(c, "#") && c != "#CCCCCC" {
+				strokes = append(strokes, c)
+			}
+		}
+		return strokes
+	}
+
+	a, b := colors(42), colors(42)
+	if len(a) != 3 || !slices.Equal(a, b) {
+		t.Errorf("SetColorSeed(42) colors = %v and %v, want the same 3 colors", a, b)
+	}
+	if a[0] == a[1] {
+		t.Errorf("SetColorSeed(42) assigned the same color to different actors: %v", a)
+	}
+	if a[2] != "#123456" {
+		t.Errorf("SetColorSeed(42) replaced an explicit step color: %v", a)
+	}
+
+	// the palette colors are not written into the steps
+	s := svgsequence.NewSequence()
+	s.SetColorByActor(true)
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+	var before, after strings.Builder
+	if err := s.WritePlantUML(&before); err != nil {
+		t.Fatal(err)
+	}
+	canonical := s.Canonical()
+	if _, err := s.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WritePlantUML(&after); err != nil {
+		t.Fatal(err)
+	}
+	if s.Canonical() != canonical || after.String() != before.String() {
+		t.Errorf("Generate() with SetColorByActor(true) changed the sequence")
+	}
+}
+
+func TestDecision(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "login"})
+	s.AddDecision("B", "valid?")
+	s.AddStep(svgsequence.Step{Source: "B", Target: "A", Text: "yes", Color: "#00AA00"})
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`<path class="seq-decision" d="M 290 98 L 300 108 L 290 118 L 280 108 z" fill="#FFFFFF" stroke="#000000" stroke-width="2"></path>`,
+		`<text class="seq-desc" x="304" y="111" fill="#000000" stroke="none" font-size="10" text-anchor="start">valid?</text>`,
+		// the decision takes a step height
+		`<line x1="290" y1="168" x2="115" y2="168"`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("AddDecision() output does not contain %s", want)
+		}
+	}
+}
+
+func TestXMLDeclaration(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(got, "<svg") {
+		t.Errorf("Generate() output does not start with <svg: %.40s", got)
+	}
+
+	s.SetXMLDeclaration(true)
+	s.SetStylesheet(`theme.css?a=1&b="2"`)
+	got, err = s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<?xml-stylesheet type=\"text/css\" href=\"theme.css?a=1&amp;b=&#34;2&#34;\"?>\n<svg"
+	if !strings.HasPrefix(got, want) {
+		t.Errorf("SetXMLDeclaration() output does not start with %s: %.120s", want, got)
+	}
+}
+
+func TestMaxDescriptionLines(t *testing.T) {
+	tests := []struct {
+		text       string
+		wantLines  []string
+		wantHeight string
+		wantTitle  bool
+	}{
+		{"one\ntwo", []string{">one</text>", ">two</text>"}, `viewBox="0 0 400 112"`, false},
+		{"one\ntwo\nthree", []string{">one</text>", ">two…</text>"}, `viewBox="0 0 400 112"`, true},
+	}
+	for _, tt := range tests {
+		s := svgsequence.NewSequence()
+		s.SetMaxDescriptionLines(2)
+		s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: tt.text})
+		got, err := s.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, want := range append(tt.wantLines, tt.wantHeight) {
+			if !strings.Contains(got, want) {
+				t.Errorf("SetMaxDescriptionLines(2) with %q output does not contain %s", tt.text, want)
+			}
+		}
+		if strings.Contains(got, ">three</text>") {
+			t.Errorf("SetMaxDescriptionLines(2) with %q output contains the third line", tt.text)
+		}
+		if strings.Contains(got, "<title>one&#xA;two&#xA;three</title>") != tt.wantTitle {
+			t.Errorf("SetMaxDescriptionLines(2) with %q tooltip = %v, want %v", tt.text, !tt.wantTitle, tt.wantTitle)
+		}
+	}
+}
+
+func TestAutoLabelPlacement(t *testing.T) {
+	build := func(auto bool) string {
+		s := svgsequence.NewSequence()
+		s.SetStepHeight(6)
+		s.SetAutoLabelPlacement(auto)
+		s.OpenSection("first", nil)
+		s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+		s.CloseSection()
+		s.OpenSection("second", nil)
+		s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+		s.CloseSection()
+		got, err := s.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	want := `writing-mode="tb" transform="rotate(180,16,29)">second</text>`
+	if got := build(false); strings.Contains(got, want) {
+		t.Errorf("labels without SetAutoLabelPlacement() should be horizontal")
+	}
+	got := build(true)
+	if !strings.Contains(got, want) {
+		t.Errorf("SetAutoLabelPlacement() output does not contain %s", want)
+	}
+	if !strings.Contains(got, `text-anchor="start">first</text>`) {
+		t.Errorf("SetAutoLabelPlacement() output does not keep the first label horizontal")
+	}
+}
+
+func TestDescriptionBelow(t *testing.T) {
+	tests := []struct {
+		below string
+		want  []string
+	}{
+		{"", []string{`<line x1="290" y1="118" x2="115" y2="118"`, `viewBox="0 0 400 144"`}},
+		{"ok", []string{`y="82" fill="#000000" stroke="none" font-size="10" text-anchor="middle">ok</text>`, `<line x1="290" y1="132" x2="115" y2="132"`, `viewBox="0 0 400 160"`}},
+		{"ok\n200", []string{`y="96" fill="#000000" stroke="none" font-size="10" text-anchor="middle">200</text>`, `<line x1="290" y1="146" x2="115" y2="146"`, `viewBox="0 0 400 176"`}},
+	}
+	for _, tt := range tests {
+		s := svgsequence.NewSequence()
+		s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "request", DescriptionBelow: tt.below})
+		s.AddStep(svgsequence.Step{Source: "B", Target: "A", Text: "next"})
+		got, err := s.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the first step keeps its position above and the next one is pushed down
+		want := append(tt.want, `<line x1="110" y1="68" x2="285" y2="68"`, `y="61" fill="#000000" stroke="none" font-size="10" text-anchor="middle">request</text>`)
+		for _, w := range want {
+			if !strings.Contains(got, w) {
+				t.Errorf("DescriptionBelow %q output does not contain %s", tt.below, w)
+			}
+		}
+	}
+}
+
+func TestSetActorX(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+	s.AddStep(svgsequence.Step{Source: "C", Target: "D"})
+	s.AddStep(svgsequence.Step{Source: "D", Target: "E"})
+	s.SetActorX("B", 150)
+	s.SetActorX("D", 500)
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		`viewBox="0 0 790 200"`,
+		`<text class="seq-actor-b" x="150" y="18" fill="#000000" stroke="none" font-size="16" text-anchor="middle">B</text>`, // pinned
+		`<text class="seq-actor-c" x="325" y="18" fill="#000000" stroke="none" font-size="16" text-anchor="middle">C</text>`, // between the pinned actors
+		`<text class="seq-actor-d" x="500" y="18" fill="#000000" stroke="none" font-size="16" text-anchor="middle">D</text>`, // pinned
+		`<text class="seq-actor-e" x="680" y="18" fill="#000000" stroke="none" font-size="16" text-anchor="middle">E</text>`, // at the default distance
+	}
+	for _, w := range want {
+		if !strings.Contains(got, w) {
+			t.Errorf("SetActorX() output does not contain %s", w)
+		}
+	}
+
+	s.SetActorX("E", 400)
+	if _, err := s.Generate(); err == nil {
+		t.Errorf("SetActorX() with decreasing positions should return an error")
+	}
+}
+
+func TestActorClass(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.AddActors("External System", "external-system", "Ñandú (v2)", "!!")
+	s.AddStep(svgsequence.Step{Source: "External System", Target: "external-system"})
+	s.AddStep(svgsequence.Step{Source: "Ñandú (v2)", Target: "!!"})
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, slug := range []string{"external-system", "external-system-2", "ñandú-v2", "actor"} {
+		want := []string{
+			`<line class="seq-actor-line seq-actor-` + slug + `"`,
+			`<text class="seq-actor-` + slug + `"`,
+		}
+		for _, w := range want {
+			if !strings.Contains(got, w) {
+				t.Errorf("actor class output does not contain %s", w)
+			}
+		}
+	}
+}
+
+func TestTimeDirection(t *testing.T) {
+	tests := []struct {
+		direction string
+		want      string
+	}{
+		{"down", timeDown},
+		{"up", timeUp},
+	}
+	for _, tt := range tests {
+		s := svgsequence.NewSequence()
+		s.SetTimeDirection(tt.direction)
+		s.AddDatabaseActor("DB")
+		s.OpenSection("Login", &svgsequence.SectionConfig{Padding: 6})
+		s.AddStep(svgsequence.Step{Source: "User", Target: "API", Text: "login"})
+		s.AddStep(svgsequence.Step{Source: "API", Target: "DB", Text: "query"})
+		s.CloseSection()
+		s.AddStep(svgsequence.Step{Source: "API", Target: "Cache", Text: "create", CreatesTarget: true})
+		s.AddNote("cached", "Cache")
+		s.AddStep(svgsequence.Step{Source: "API", Target: "User", Text: "token", DescriptionBelow: "200 OK"})
+		got, err := s.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != tt.want {
+			gotFn := "got_time_" + tt.direction + ".svg"
+			wantFn := "want_time_" + tt.direction + ".svg"
+			t.Errorf(`SetTimeDirection(%q) failed, resulting svg files saved as "%s" and "%s"`, tt.direction, gotFn, wantFn)
+			_ = os.WriteFile(gotFn, []byte(got), 0o644)
+			_ = os.WriteFile(wantFn, []byte(tt.want), 0o644)
+		}
+	}
+}
+
+func TestHash(t *testing.T) {
+	build := func(text string) *svgsequence.Sequence {
+		s := svgsequence.NewSequence()
+		s.SetColorByActor(true)
+		s.OpenSection("Section", nil)
+		s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: text})
+		s.CloseSection()
+		s.AddNote("note", "A")
+		return s
+	}
+
+	a, b := build("request"), build("request")
+	if a.Hash() != b.Hash() {
+		t.Errorf("Hash() of equal sequences differ: %s != %s", a.Hash(), b.Hash())
+	}
+
+	// the layout computed by Generate is not part of the hash
+	before := a.Hash()
+	if _, err := a.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	if a.Hash() != before {
+		t.Errorf("Hash() changed after Generate(): %s != %s", a.Hash(), before)
+	}
+
+	if c := build("response"); c.Hash() == before {
+		t.Errorf("Hash() of sequences with a different step should differ")
+	}
+	b.SetStepHeight(80)
+	if b.Hash() == before {
+		t.Errorf("Hash() of sequences with different options should differ")
+	}
+}
+
+func TestPreserveWhitespace(t *testing.T) {
+	for _, preserve := range []bool{false, true} {
+		s := svgsequence.NewSequence()
+		s.SetPreserveWhitespace(preserve)
+		s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "  +--+  "})
+		got, err := s.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := `text-anchor="middle" xml:space="preserve">  +--+  </text>`
+		if strings.Contains(got, want) != preserve {
+			t.Errorf("SetPreserveWhitespace(%v) output contains %s = %v", preserve, want, !preserve)
+		}
+	}
+}
+
+func TestActiveLayers(t *testing.T) {
+	build := func(layers ...string) string {
+		s := svgsequence.NewSequence()
+		s.SetActiveLayers(layers...)
+		s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "request"})
+		s.OpenSection("Internal", nil)
+		s.AddStep(svgsequence.Step{Source: "B", Target: "C", Text: "lookup", Layers: []string{"internal"}})
+		s.CloseSection()
+		s.AddStep(svgsequence.Step{Source: "B", Target: "A", Text: "response", Layers: []string{"public", "internal"}})
+		got, err := s.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	tests := []struct {
+		layers  []string
+		want    []string
+		notWant []string
+	}{
+		{nil, []string{">request</text>", ">lookup</text>", ">response</text>", ">Internal</text>"}, nil},
+		{[]string{"internal"}, []string{">request</text>", ">lookup</text>", ">response</text>", ">Internal</text>"}, nil},
+		{[]string{"public"}, []string{">request</text>", ">response</text>", `viewBox="0 0 400 144"`}, []string{">lookup</text>", ">Internal</text>", ">C</text>"}},
+		{[]string{"other"}, []string{">request</text>", `viewBox="0 0 400 96"`}, []string{">lookup</text>", ">response</text>"}},
+	}
+	for _, tt := range tests {
+		got := build(tt.layers...)
+		for _, want := range tt.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("SetActiveLayers(%q) output does not contain %s", tt.layers, want)
+			}
+		}
+		for _, notWant := range tt.notWant {
+			if strings.Contains(got, notWant) {
+				t.Errorf("SetActiveLayers(%q) output contains %s", tt.layers, notWant)
+			}
+		}
+	}
+}
+
+func TestGroupDividers(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.SetGroupDividers(true)
+	s.AddActorGroup("Frontend", "Browser", "CDN")
+	s.AddActorGroup("Backend", "API", "DB")
+	s.AddActorGroup("External", "Payments")
+	s.AddStep(svgsequence.Step{Source: "Browser", Target: "API"})
+	s.AddStep(svgsequence.Step{Source: "API", Target: "Payments"})
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := strings.Count(got, `class="seq-group-divider"`); n != 2 {
+		t.Errorf("SetGroupDividers() output contains %d dividers, want 2", n)
+	}
+	// halfway between CDN and API, and between DB and Payments
+	for _, want := range []string{
+		`<line class="seq-group-divider" x1="380" y1="0" x2="380" y2="144" stroke="#EEEEEE" stroke-width="1"></line>`,
+		`<line class="seq-group-divider" x1="740" y1="0" x2="740"`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("SetGroupDividers() output does not contain %s", want)
+		}
+	}
+}
+
+func TestGenerateWithLayout(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.OpenSection("Section", nil)
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "request"})
+	s.AddStep(svgsequence.Step{Source: "B", Target: "A", Text: "response"})
+	s.CloseSection()
+	got, layout, err := s.GenerateWithLayout()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(layout.Actors) != 2 || len(layout.Steps) != 2 || len(layout.Sections) != 1 {
+		t.Fatalf("GenerateWithLayout() layout = %+v, want 2 actors, 2 steps and 1 section", layout)
+	}
+	want := []string{fmt.Sprintf(`viewBox="0 0 %g %g"`, layout.Width, layout.Height)}
+	for _, a := range layout.Actors {
+		want = append(want, fmt.Sprintf(`x="%g" y="18" fill="#000000" stroke="none" font-size="16" text-anchor="middle">%s</text>`, a.X, a.Name))
+	}
+	for _, st := range layout.Steps {
+		want = append(want, fmt.Sprintf(`<line x1="%g" y1="%g"`, st.X1, st.Y))
+	}
+	for _, sec := range layout.Sections {
+		want = append(want, fmt.Sprintf(`<rect x="%g" y="%g" width="%g" height="%g"`, sec.X, sec.Y, sec.Width, sec.Height))
+	}
+	for _, w := range want {
+		if !strings.Contains(got, w) {
+			t.Errorf("GenerateWithLayout() output does not contain %s", w)
+		}
+	}
+}
+
+func TestAddActorsNormalization(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.AddActors("\tAPI", "DB\u00a0", "Data\tBase", "数据库", "Caché 🚀")
+
+	wantActors := []string{"API", "DB", "数据库", "Caché 🚀"}
+	if got := s.Actors(); !slices.Equal(got, wantActors) {
+		t.Errorf("AddActors() actors = %q, want %q", got, wantActors)
+	}
+	wantWarnings := []string{
+		`actor "\tAPI" trimmed to "API"`,
+		`actor "DB\u00a0" trimmed to "DB"`,
+		`actor "Data\tBase" ignored: the name contains control characters`,
+	}
+	if got := s.Warnings(); !slices.Equal(got, wantWarnings) {
+		t.Errorf("AddActors() warnings = %q, want %q", got, wantWarnings)
+	}
+}
+
+func TestActorOptionsNormalization(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "hello"})
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "again"})
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "more"})
+	s.SetActorColor(" B", "red")
+	s.SetActorX("B ", 300)
+	s.SuspendActor(" B ", 1, 1)
+
+	if idx, ok := s.ActorIndex(" B"); idx != 1 || !ok {
+		t.Errorf("ActorIndex() = %d, %t, want 1, true", idx, ok)
+	}
+
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<text class="seq-actor-b" x="300" y="18" fill="red"`,
+		`<line class="seq-actor-line seq-actor-b" x1="300" y1="26" x2="300" y2="93"`,
+		`<line class="seq-actor-line seq-actor-b" x1="300" y1="143" x2="300" y2="200"`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Generate() output does not contain %s", want)
+		}
+	}
+}
+
+func TestAppendActorsNormalization(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.AppendActors(" API", "Data	Base")
+	s.AddDatabaseActor("DB ")
+	s.AddStep(svgsequence.Step{Source: "API ", Target: " DB", Text: "query"})
+	s.AddNote("cached", " DB")
+
+	wantActors := []string{"API", "DB"}
+	if got := s.Actors(); !slices.Equal(got, wantActors) {
+		t.Errorf("AppendActors() actors = %q, want %q", got, wantActors)
+	}
+	wantWarnings := []string{
+		`actor " API" trimmed to "API"`,
+		`actor "Data\tBase" ignored: the name contains control characters`,
+		`actor "DB " trimmed to "DB"`,
+		`actor "API " trimmed to "API"`,
+		`actor " DB" trimmed to "DB"`,
+		`actor " DB" trimmed to "DB"`,
+	}
+	if got := s.Warnings(); !slices.Equal(got, wantWarnings) {
+		t.Errorf("AppendActors() warnings = %q, want %q", got, wantWarnings)
+	}
+
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the step and the note are drawn between the normalized actors
+	for _, want := range []string{
+		`<line x1="110" y1="102" x2="285" y2="102"`,
+		`x="290" y="149" fill="#000000" stroke="none" font-size="10" text-anchor="middle">cached</text>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("AppendActors() output does not contain %s", want)
+		}
+	}
+}
+
+func TestSharedDefs(t *testing.T) {
+	defs := svgsequence.SharedDefs()
+	for _, want := range []string{"<defs>", `<style>`, `<marker id="seq-dot"`, `<marker id="seq-arrow"`, `<marker id="seq-arrow-open"`, `<g id="seq-clock">`} {
+		if !strings.Contains(defs, want) {
+			t.Errorf("SharedDefs() does not contain %s", want)
+		}
+	}
+
+	s := svgsequence.NewSequence()
+	s.SetIncludeDefs(false)
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B", Duration: "10ms"})
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(got, "<defs>") {
+		t.Errorf("SetIncludeDefs(false) output contains the <defs> element")
+	}
+	// the shared definitions are still referenced
+	for _, want := range []string{`marker-end="url(#seq-arrow)"`, `href="#seq-clock"`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("SetIncludeDefs(false) output does not contain %s", want)
+		}
+	}
+}
+
+func TestShowTimestamps(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.SetShowTimestamps(true)
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B", Timestamp: "0ms"})
+	s.AddStep(svgsequence.Step{Source: "B", Target: "A"})
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B", Timestamp: "1250ms"})
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the gutter fits the longest timestamp (6 characters) and the padding
+	for _, want := range []string{
+		`viewBox="0 0 444 200"`,
+		`<text class="seq-actor-a" x="154" y="18"`,
+		`<text class="seq-timestamp" x="56" y="71" fill="#666666" stroke="none" font-size="10" text-anchor="end">0ms</text>`,
+		`<text class="seq-timestamp" x="56" y="171" fill="#666666" stroke="none" font-size="10" text-anchor="end">1250ms</text>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("SetShowTimestamps() output does not contain %s", want)
+		}
+	}
+	if n := strings.Count(got, `class="seq-timestamp"`); n != 2 {
+		t.Errorf("SetShowTimestamps() output contains %d timestamps, want 2", n)
+	}
+}
+
+func TestSnapHeightToDash(t *testing.T) {
+	tests := []struct {
+		snap bool
+		want string
+	}{
+		{true, `viewBox="0 0 400 88"`},
+		// actors (18) + step (45) + half step margin (22)
+		{false, `viewBox="0 0 400 85"`},
+	}
+	for _, tt := range tests {
+		s := svgsequence.NewSequence()
+		s.SetStepHeight(45)
+		s.SetSnapHeightToDash(tt.snap)
+		s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+		got, err := s.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(got, tt.want) {
+			t.Errorf("SetSnapHeightToDash(%v) output does not contain %s", tt.snap, tt.want)
+		}
+	}
+}
+
+func TestGenerateOverview(t *testing.T) {
+	s := svgsequence.NewSequence()
+	for range 20 {
+		s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "request"})
+		s.AddStep(svgsequence.Step{Source: "B", Target: "C", Text: "lookup"})
+	}
+	s.AddNote("done", "A", "C")
+	got, err := s.GenerateOverview(0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := strings.Count(got, `class="seq-overview-step"`); n != 41 {
+		t.Errorf("GenerateOverview() output contains %d dots, want 41", n)
+	}
+	if n := strings.Count(got, `class="seq-overview-actor"`); n != 3 {
+		t.Errorf("GenerateOverview() output contains %d lifelines, want 3", n)
+	}
+	for _, want := range []string{`width="290" height="`, `<circle id="seq-overview-step-1" class="seq-overview-step" cx="100" cy="34" r="2" fill="#000000"></circle>`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("GenerateOverview() output does not contain %s", want)
+		}
+	}
+	if strings.Contains(got, "request") {
+		t.Errorf("GenerateOverview() output contains the descriptions")
+	}
+
+	if _, err := s.GenerateOverview(0); err == nil {
+		t.Errorf("GenerateOverview(0) should return an error")
+	}
+}
+
+func TestLineCap(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(got, "stroke-linecap") {
+		t.Errorf("default output contains stroke-linecap")
+	}
+
+	s.SetLineCap("round")
+	s.AddStep(svgsequence.Step{Source: "A", Target: "A", SelfStyle: "loop"})
+	got, err = s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the arrow and the three lines of the loop
+	if n := strings.Count(got, `stroke-linecap="round"`); n != 4 {
+		t.Errorf(`SetLineCap("round") output contains %d round lines, want 4`, n)
+	}
+
+	// the invalid shapes are ignored
+	s.SetLineCap("rounded")
+	got, err = s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(got, `stroke-linecap="round"`); n != 4 || strings.Contains(got, "rounded") {
+		t.Errorf(`SetLineCap("rounded") changed the round lines`)
+	}
+}
+
+func TestAddRef(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.AddActors("A", "B", "C", "D")
+	s.AddStep(svgsequence.Step{Source: "A", Target: "D"})
+	s.AddRef("see Login", "C", "B")
+	s.AddRef("see Logout")
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		// from B to C, in any order
+		`<rect class="seq-ref" x="245" y="86" width="270" height="36"`,
+		`text-anchor="start">ref</text>`,
+		`<text class="seq-desc" x="380" y="115" fill="#000000" stroke="none" font-size="10" text-anchor="middle">see Login</text>`,
+		// over all the actors
+		`<rect class="seq-ref" x="65" y="136" width="630" height="36"`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("AddRef() output does not contain %s", want)
+		}
+	}
+	if n := strings.Count(got, ">ref</text>"); n != 2 {
+		t.Errorf("AddRef() output contains %d ref tabs, want 2", n)
+	}
+}
+
+func TestStepOpacity(t *testing.T) {
+	tests := []struct {
+		opacity float64
+		want    bool
+	}{
+		{0, false},
+		{1, false},
+		{0.4, true},
+	}
+	for _, tt := range tests {
+		s := svgsequence.NewSequence()
+		s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "context", Opacity: tt.opacity})
+		got, err := s.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the line and the description are faded together
+		want := `<g opacity="0.4">` + "\n" + `    <line x1="110" y1="68"`
+		if strings.Contains(got, want) != tt.want {
+			t.Errorf("Opacity %g output contains %s = %v, want %v", tt.opacity, want, !tt.want, tt.want)
+		}
+		if strings.Contains(got, "opacity=") && !tt.want {
+			t.Errorf("Opacity %g output contains the opacity attribute", tt.opacity)
+		}
+	}
+}
+
+func TestNewSequenceWithOptions(t *testing.T) {
+	opts := svgsequence.Options{Width: "900px", Distance: 100, StepHeight: 40, NoteStyle: "folded", DefaultColor: "#336699"}
+	s := svgsequence.NewSequenceWithOptions(opts)
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+	s.AddNote("note", "A")
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`width="900px" height="100%" viewBox="0 0 240 `,
+		`<line x1="70" y1="58" x2="165" y2="58" fill="#336699" stroke="#336699"`,
+		`<path class="seq-note"`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("NewSequenceWithOptions(%+v) output does not contain %s", opts, want)
+		}
+	}
+
+	// the zero options keep the defaults
+	a, b := svgsequence.NewSequenceWithOptions(svgsequence.Options{}), svgsequence.NewSequence()
+	if a.Hash() != b.Hash() {
+		t.Errorf("NewSequenceWithOptions() with zero options differs from NewSequence()")
+	}
+
+	for _, invalid := range []svgsequence.Options{
+		{Distance: -1},
+		{StepHeight: -10},
+		{DistanceFraction: 2},
+		{DistanceFraction: math.NaN()},
+		{Alignment: "middle"},
+		{NoteStyle: "round"},
+		{TimeDirection: "left"},
+	} {
+		if _, err := svgsequence.NewSequenceWithValidOptions(invalid); err == nil {
+			t.Errorf("NewSequenceWithValidOptions(%+v) should return an error", invalid)
+		}
+	}
+	if _, err := svgsequence.NewSequenceWithValidOptions(opts); err != nil {
+		t.Errorf("NewSequenceWithValidOptions(%+v) returned an error: %v", opts, err)
+	}
+}
+
+func TestAdaptiveStepHeight(t *testing.T) {
+	tests := []struct {
+		adaptive bool
+		want     []string
+	}{
+		{false, []string{`<line x1="110" y1="68"`, `<line x1="290" y1="118"`, `<line x1="110" y1="168"`, `viewBox="0 0 400 200"`}},
+		// the step without description uses half the step height
+		{true, []string{`<line x1="110" y1="68"`, `<line x1="290" y1="93"`, `<line x1="110" y1="143"`, `viewBox="0 0 400 168"`}},
+	}
+	for _, tt := range tests {
+		s := svgsequence.NewSequence()
+		s.SetAdaptiveStepHeight(tt.adaptive)
+		s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "request"})
+		s.AddStep(svgsequence.Step{Source: "B", Target: "A"})
+		s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "retry"})
+		got, err := s.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("SetAdaptiveStepHeight(%v) output does not contain %s", tt.adaptive, want)
+			}
+		}
+	}
+}
+
+func TestSuspendActor(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+	s.AddStep(svgsequence.Step{Source: "B", Target: "C"})
+	s.AddStep(svgsequence.Step{Source: "C", Target: "B"})
+	s.AddStep(svgsequence.Step{Source: "B", Target: "A"})
+	s.SuspendActor("A", 1, 2)
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the steps 1 and 2 are at y=118 and y=168, the gap spans half a step around them
+	for _, want := range []string{
+		`<line class="seq-actor-line seq-actor-a" x1="110" y1="26" x2="110" y2="93"`,
+		`<line class="seq-actor-line seq-actor-a" x1="110" y1="193" x2="110" y2="248"`,
+		`<line class="seq-actor-line seq-actor-b" x1="290" y1="26" x2="290" y2="248"`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("SuspendActor() output does not contain %s", want)
+		}
+	}
+	if n := strings.Count(got, `seq-actor-line seq-actor-a"`); n != 2 {
+		t.Errorf("SuspendActor() output contains %d lifeline segments, want 2", n)
+	}
+
+	s.SuspendActor("B", 2, 4)
+	if _, err := s.Generate(); err == nil {
+		t.Errorf("SuspendActor() with an invalid step should return an error")
+	}
+}
+
+func TestSuspendActorWithLayers(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B", Layers: []string{"detail"}})
+	s.AddStep(svgsequence.Step{Source: "B", Target: "C"})
+	s.AddStep(svgsequence.Step{Source: "C", Target: "B"})
+	s.AddStep(svgsequence.Step{Source: "B", Target: "A"})
+	s.SuspendActor("A", 1, 2)
+	// only spans a step that is not generated
+	s.SuspendActor("B", 0, 0)
+	s.SetActiveLayers("other")
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the suspended steps are the first and second ones generated, at y=68 and y=118
+	for _, want := range []string{
+		`<line class="seq-actor-line seq-actor-a" x1="110" y1="26" x2="110" y2="43"`,
+		`<line class="seq-actor-line seq-actor-a" x1="110" y1="143" x2="110" y2="200"`,
+		`<line class="seq-actor-line seq-actor-b" x1="290" y1="26" x2="290" y2="200"`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("SuspendActor() with layers output does not contain %s", want)
+		}
+	}
+}
+
+func TestSelfLabelSide(t *testing.T) {
+	tests := []struct {
+		side string
+		want string
+	}{
+		{"", `x="118" y="61" fill="#000000" stroke="none" font-size="10" text-anchor="start">validate</text>`},
+		{"right", `x="118" y="61" fill="#000000" stroke="none" font-size="10" text-anchor="start">validate</text>`},
+		{"left", `x="102" y="61" fill="#000000" stroke="none" font-size="10" text-anchor="end">validate</text>`},
+	}
+	for _, tt := range tests {
+		s := svgsequence.NewSequence()
+		s.SetSelfLabelSide(tt.side)
+		s.AddStep(svgsequence.Step{Source: "A", Target: "A", Text: "validate"})
+		got, err := s.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(got, tt.want) {
+			t.Errorf("SetSelfLabelSide(%q) output does not contain %s", tt.side, tt.want)
+		}
+	}
+}
+
+func TestSelfLabelOffset(t *testing.T) {
+	tests := []struct {
+		offset int
+		want   string
+	}{
+		{0, `x="110" y="61" fill="#000000" stroke="none" font-size="10" text-anchor="start">validate</text>`},
+		{20, `x="130" y="61" fill="#000000" stroke="none" font-size="10" text-anchor="start">validate</text>`},
+		{-5, `x="118" y="61" fill="#000000" stroke="none" font-size="10" text-anchor="start">validate</text>`}, // ignored
+	}
+	for _, tt := range tests {
+		s := svgsequence.NewSequence()
+		s.SetSelfLabelOffset(tt.offset)
+		s.AddStep(svgsequence.Step{Source: "A", Target: "A", Text: "validate"})
+		got, err := s.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(got, tt.want) {
+			t.Errorf("SetSelfLabelOffset(%d) output does not contain %s", tt.offset, tt.want)
+		}
+	}
+}
+
+func TestSetAnnotate(t *testing.T) {
+	classes := map[svgsequence.AnnotateFlags][]string{
+		svgsequence.AnnotateActors:       {`class="seq-actor"`, `class="seq-actor-line seq-actor-a"`, `class="seq-actor-a"`},
+		svgsequence.AnnotateSteps:        {`class="seq-step"`, `class="seq-note"`},
+		svgsequence.AnnotateSections:     {`class="seq-section"`, `class="seq-section-label"`},
+		svgsequence.AnnotateDescriptions: {`class="seq-desc"`},
+	}
+	tests := []svgsequence.AnnotateFlags{
+		svgsequence.AnnotateAll,
+		svgsequence.AnnotateNone,
+		svgsequence.AnnotateActors,
+		svgsequence.AnnotateSteps | svgsequence.AnnotateSections,
+		svgsequence.AnnotateDescriptions,
+	}
+	for _, flags := range tests {
+		s := svgsequence.NewSequence()
+		s.SetAnnotate(flags)
+		s.OpenSection("retry", nil)
+		s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "request"})
+		s.CloseSection()
+		s.AddNote("cached", "B")
+		got, err := s.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for flag, want := range classes {
+			for _, w := range want {
+				if annotated := strings.Contains(got, w); annotated != (flags&flag != 0) {
+					t.Errorf("SetAnnotate(%b) output contains %s = %v, want %v", flags, w, annotated, !annotated)
+				}
+			}
+		}
+		// the markers are always referenced by their ids
+		if !strings.Contains(got, `id="seq-arrow"`) {
+			t.Errorf("SetAnnotate(%b) output does not contain the arrow marker id", flags)
+		}
+	}
+
+	// by default the arrows and sections have no class
+	s := svgsequence.NewSequence()
+	s.OpenSection("retry", nil)
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "request"})
+	s.CloseSection()
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, class := range []string{"seq-step", "seq-section", "seq-section-label"} {
+		if strings.Contains(got, `class="`+class+`"`) {
+			t.Errorf("default output contains the %s class", class)
+		}
+	}
+	if !strings.Contains(got, `class="seq-actor"`) || !strings.Contains(got, `class="seq-desc"`) {
+		t.Errorf("default output does not contain the actor and description classes")
+	}
+}
+
+func TestLabelClampToArrow(t *testing.T) {
+	tests := []struct {
+		clamp bool
+		want  []string
+	}{
+		{false, []string{
+			`x="80" y="61" fill="#000000" stroke="none" font-size="10" text-anchor="middle">a very long description</text>`,
+			`x="80" y="111" fill="#000000" stroke="none" font-size="10" text-anchor="middle">a very long description</text>`,
+			`x="80" y="161" fill="#000000" stroke="none" font-size="10" text-anchor="middle">short</text>`,
+		}},
+		{true, []string{
+			`x="55" y="61" fill="#000000" stroke="none" font-size="10" text-anchor="start">a very long description</text>`,
+			`x="105" y="111" fill="#000000" stroke="none" font-size="10" text-anchor="end">a very long description</text>`,
+			// the labels that fit the arrow stay centered
+			`x="80" y="161" fill="#000000" stroke="none" font-size="10" text-anchor="middle">short</text>`,
+		}},
+	}
+	for _, tt := range tests {
+		s := svgsequence.NewSequence()
+		s.SetDistance(60)
+		s.SetLabelClampToArrow(tt.clamp)
+		s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "a very long description"})
+		s.AddStep(svgsequence.Step{Source: "B", Target: "A", Text: "a very long description"})
+		s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "short"})
+		got, err := s.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("SetLabelClampToArrow(%v) output does not contain %s", tt.clamp, want)
+			}
+		}
+	}
+}
+
+func TestSetLabelStyle(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.SetLabelStyle("tab")
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "hello"})
+	s.AddStep(svgsequence.Step{Source: "B", Target: "A", Text: "two\nlines"})
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "a very long description that does not fit in the arrow"})
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		// the arrow is split around the tab
+		`<line x1="110" y1="68" x2="181" y2="68" fill="#000000" stroke="#000000" stroke-width="2" marker-start="url(#seq-dot)"></line>`,
+		`<line x1="219" y1="68" x2="285" y2="68" fill="#000000" stroke="#000000" stroke-width="2" marker-end="url(#seq-arrow)"></line>`,
+		`<rect class="seq-label-tab" x="181" y="61" width="38" height="14" fill="white" stroke="#000000" stroke-width="1"></rect>`,
+		`<text class="seq-desc" x="200" y="72" fill="#000000" stroke="none" font-size="10" text-anchor="middle">hello</text>`,
+		// right to left, the tab grows with the lines
+		`<line x1="290" y1="132" x2="219" y2="132" fill="#000000" stroke="#000000" stroke-width="2" marker-start="url(#seq-dot)"></line>`,
+		`<line x1="181" y1="132" x2="115" y2="132" fill="#000000" stroke="#000000" stroke-width="2" marker-end="url(#seq-arrow)"></line>`,
+		`<rect class="seq-label-tab" x="181" y="118" width="38" height="28" fill="white" stroke="#000000" stroke-width="1"></rect>`,
+		`<text class="seq-desc" x="200" y="129" fill="#000000" stroke="none" font-size="10" text-anchor="middle">two</text>`,
+		`<text class="seq-desc" x="200" y="143" fill="#000000" stroke="none" font-size="10" text-anchor="middle">lines</text>`,
+		// the tab does not fit the arrow
+		`<line x1="110" y1="182" x2="285" y2="182" fill="#000000" stroke="#000000" stroke-width="2" marker-start="url(#seq-dot)" marker-end="url(#seq-arrow)"></line>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("SetLabelStyle(\"tab\") output does not contain %s", want)
+		}
+	}
+	if n := strings.Count(got, "seq-label-tab"); n != 2 {
+		t.Errorf("SetLabelStyle(\"tab\") drew %d tabs, want 2", n)
+	}
+}
+
+func TestSetFontUnit(t *testing.T) {
+	tests := []struct {
+		unit string
+		want []string
+	}{
+		{"", []string{`font-size="16" text-anchor="middle">A</text>`, `font-size="10" text-anchor="middle">hello</text>`}},
+		{"px", []string{`font-size="16" text-anchor="middle">A</text>`, `font-size="10" text-anchor="middle">hello</text>`}},
+		{"em", []string{`font-size="1em" text-anchor="middle">A</text>`, `font-size="0.625em" text-anchor="middle">hello</text>`}},
+	}
+	for _, tt := range tests {
+		s := svgsequence.NewSequence()
+		s.SetFontUnit(tt.unit)
+		s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "hello"})
+		got, err := s.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("SetFontUnit(%q) output does not contain %s", tt.unit, want)
+			}
+		}
+		// the layout does not depend on the unit
+		if want := `viewBox="0 0 400 96"`; !strings.Contains(got, want) {
+			t.Errorf("SetFontUnit(%q) output does not contain %s", tt.unit, want)
+		}
+	}
+}
+
+func TestSetElementHook(t *testing.T) {
+	s := svgsequence.NewSequence()
+	var names []string
+	s.SetElementHook(func(el any) any {
+		names = append(names, svgsequence.ElementName(el))
+		switch svgsequence.ElementName(el) {
+		case "line":
+			return svgsequence.SetElementAttr(svgsequence.AddElementClass(el, "custom"), "data-step", "1")
+		case "text":
+			// drop the descriptions
+			return nil
+		}
+		return el
+	})
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "hello"})
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"defs", "rect", "g", "g", "line", "text"}; !slices.Equal(names, want) {
+		t.Errorf("SetElementHook() called with %v, want %v", names, want)
+	}
+	if want := `<line class="custom" x1="110" y1="68" x2="285" y2="68" fill="#000000" stroke="#000000" stroke-width="2" marker-start="url(#seq-dot)" marker-end="url(#seq-arrow)" data-step="1"></line>`; !strings.Contains(got, want) {
+		t.Errorf("SetElementHook() output does not contain %s", want)
+	}
+	if strings.Contains(got, "hello") {
+		t.Errorf("SetElementHook() output contains the dropped description")
+	}
+}
+
+func TestPrefixActorInLabel(t *testing.T) {
+	tests := []struct {
+		prefix bool
+		want   []string
+	}{
+		{false, []string{`viewBox="0 0 400 336"`, `>hello</text>`, `>a long vertical description</text>`}},
+		// the vertical description is taller with the prefix
+		{true, []string{`viewBox="0 0 400 368"`, `>Alice: hello</text>`, `>world</text>`, `>Bob: a long vertical description</text>`}},
+	}
+	for _, tt := range tests {
+		s := svgsequence.NewSequence()
+		s.SetPrefixActorInLabel(tt.prefix)
+		s.AddStep(svgsequence.Step{Source: "Alice", Target: "Bob", Text: "hello\nworld"})
+		s.AddNote("noted", "Bob")
+		s.AddStep(svgsequence.Step{Source: "Bob", Target: "Alice", Text: "a long vertical description", VerticalText: true})
+		got, err := s.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range append(tt.want, `>noted</text>`) {
+			if !strings.Contains(got, want) {
+				t.Errorf("SetPrefixActorInLabel(%v) output does not contain %s", tt.prefix, want)
+			}
+		}
+	}
+}
+
+func TestSectionRanges(t *testing.T) {
+	tests := []struct {
+		name          string
+		first, second [2]int
+		wantErr       string
+	}{
+		{"nested", [2]int{0, 3}, [2]int{1, 2}, ""},
+		{"same steps", [2]int{0, 3}, [2]int{0, 3}, ""},
+		{"disjoint", [2]int{0, 1}, [2]int{2, 3}, ""},
+		{"interleaved", [2]int{0, 2}, [2]int{1, 3}, "found interleaved sections: A and B"},
+		{"interleaved reversed", [2]int{1, 3}, [2]int{0, 2}, "found interleaved sections: A and B"},
+	}
+	for _, tt := range tests {
+		s := svgsequence.NewSequence()
+		s.OpenSection("A", nil)
+		s.OpenSection("B", nil)
+		for range 4 {
+			s.AddStep(svgsequence.Step{Source: "X", Target: "Y"})
+		}
+		s.CloseAllSections()
+		svgsequence.SetSectionRange(s, 0, tt.first[0], tt.first[1])
+		svgsequence.SetSectionRange(s, 1, tt.second[0], tt.second[1])
+
+		_, err := s.Generate()
+		if tt.wantErr == "" && err != nil {
+			t.Errorf("%s: Generate() error = %v", tt.name, err)
+		}
+		if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+			t.Errorf("%s: Generate() error = %v, want %s", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestAddTimeBreak(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "request"})
+	s.AddTimeBreak("B")
+	s.AddStep(svgsequence.Step{Source: "B", Target: "A", Text: "response"})
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		// the break takes less space than a step
+		`viewBox="0 0 400 168"`,
+		`<path class="seq-time-break" d="M 282 86 L 286 82 L 294 90 L 298 86 L 298 90 L 294 94 L 286 86 L 282 90 z" fill="#FFFFFF" stroke="#CCCCCC" stroke-width="2"></path>`,
+		`<line x1="290" y1="138" x2="115" y2="138"`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("AddTimeBreak() output does not contain %s", want)
+		}
+	}
+}
+
+func TestAutonumberFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		format func(n int) string
+		want   []string
+	}{
+		{"decimal", nil, []string{`>1 request</text>`, `>2</text>`, `>3 response</text>`}},
+		{"zero-padded", func(n int) string { return fmt.Sprintf("%02d", n) }, []string{`>01 request</text>`, `>02</text>`, `>03 response</text>`}},
+		{"prefixed", func(n int) string { return fmt.Sprintf("S%d", n) }, []string{`>S1 request</text>`, `>S2</text>`, `>S3 response</text>`}},
+		{"letters", func(n int) string { return string(rune('a' + n - 1)) }, []string{`>a request</text>`, `>b</text>`, `>c response</text>`}},
+	}
+	for _, tt := range tests {
+		s := svgsequence.NewSequence()
+		s.SetAutonumber(true)
+		s.SetAutonumberFormat(tt.format)
+		s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "request"})
+		s.AddNote("not numbered", "B")
+		s.AddStep(svgsequence.Step{Source: "B", Target: "B"})
+		s.AddStep(svgsequence.Step{Source: "B", Target: "A", Text: "response"})
+		got, err := s.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range append(tt.want, `>not numbered</text>`) {
+			if !strings.Contains(got, want) {
+				t.Errorf("SetAutonumberFormat %s: output does not contain %s", tt.name, want)
+			}
+		}
+	}
+}
+
+func TestLifelineTerminal(t *testing.T) {
+	tests := []struct {
+		terminal string
+		want     []string
+	}{
+		{"none", nil},
+		{"bar", []string{
+			`<line class="seq-lifeline-terminal" x1="98" y1="90" x2="122" y2="90"`,
+			`<line class="seq-lifeline-terminal" x1="278" y1="90" x2="302" y2="90"`,
+		}},
+		{"cross", []string{
+			`<line class="seq-lifeline-terminal" x1="104" y1="84" x2="116" y2="96"`,
+			`<line class="seq-lifeline-terminal" x1="104" y1="96" x2="116" y2="84"`,
+			`<line class="seq-lifeline-terminal" x1="284" y1="84" x2="296" y2="96"`,
+			`<line class="seq-lifeline-terminal" x1="284" y1="96" x2="296" y2="84"`,
+		}},
+	}
+	for _, tt := range tests {
+		s := svgsequence.NewSequence()
+		s.SetLifelineTerminal(tt.terminal)
+		s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "request"})
+		got, err := s.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := strings.Count(got, "seq-lifeline-terminal"); n != len(tt.want) {
+			t.Errorf("SetLifelineTerminal(%q) output contains %d terminal elements, want %d", tt.terminal, n, len(tt.want))
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("SetLifelineTerminal(%q) output does not contain %s", tt.terminal, want)
+			}
+		}
+	}
+}
+
+func TestSetViewBox(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "request"})
+	if err := s.SetViewBox(100, 40, 200, 60.5); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`viewBox="100 40 200 60.5"`,
+		`<rect x="100" y="40" width="200" height="60.5" fill="#FFFFFF"></rect>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("SetViewBox() output does not contain %s", want)
+		}
+	}
+
+	for _, size := range [][2]float64{{-1, 10}, {10, -1}} {
+		if err := s.SetViewBox(0, 0, size[0], size[1]); err == nil {
+			t.Errorf("SetViewBox(0, 0, %g, %g) returned no error", size[0], size[1])
+		}
+	}
+}
+
+func TestSetActorColor(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.SetActorColor("API", "gray")
+	s.SetActorColor("Worker", "#336699")
+	s.AddStep(svgsequence.Step{Source: "User", Target: "API", Text: "request"})
+	s.AddStep(svgsequence.Step{Source: "API", Target: "Worker", Text: "spawn", CreatesTarget: true})
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`fill="#000000" stroke="none" font-size="16" text-anchor="middle">User</text>`,
+		`fill="gray" stroke="none" font-size="16" text-anchor="middle">API</text>`,
+		// the name of a created actor too
+		`fill="#336699" stroke="none" font-size="16" text-anchor="middle">Worker</text>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("SetActorColor() output does not contain %s", want)
+		}
+	}
+}
+
+func TestStepNoArrow(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "linked", NoArrow: true})
+	s.AddStep(svgsequence.Step{Source: "B", Target: "A", NoArrow: true})
+	s.AddStep(svgsequence.Step{Source: "A", Target: "A", SelfStyle: "loop", NoArrow: true})
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		// the lines reach the lifelines
+		`<line x1="110" y1="68" x2="290" y2="68" fill="#000000" stroke="#000000" stroke-width="2"></line>`,
+		`<line x1="290" y1="118" x2="110" y2="118" fill="#000000" stroke="#000000" stroke-width="2"></line>`,
+		`<line x1="155" y1="183" x2="110" y2="183" fill="#000000" stroke="#000000" stroke-width="2"></line>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("NoArrow output does not contain %s", want)
+		}
+	}
+	if strings.Contains(got, "marker-start=") || strings.Contains(got, "marker-end=") {
+		t.Errorf("NoArrow output contains markers")
+	}
+}
+
+func TestGenerateHTML(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.SetXMLDeclaration(true)
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "request"})
+
+	got, err := s.GenerateHTML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(got, "<figure>\n<svg ") || !strings.HasSuffix(got, "</svg>\n</figure>\n") {
+		t.Errorf("GenerateHTML() =\n%s\nwant the SVG inside a figure", got)
+	}
+	if strings.Contains(got, "<figcaption>") {
+		t.Errorf("GenerateHTML() without title contains a figcaption")
+	}
+
+	s.SetTitle("Login & <logout>")
+	got, err = s.GenerateHTML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"<title>Login &amp; &lt;logout&gt;</title>",
+		"</svg>\n<figcaption>Login &amp; &lt;logout&gt;</figcaption>\n</figure>\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("GenerateHTML() output does not contain %s", want)
+		}
+	}
+}
+
+func TestStepDashPattern(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B", DashPattern: "4 2 1 2"})
+	s.AddStep(svgsequence.Step{Source: "B", Target: "A", DashPattern: "3,1.5"})
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<line x1="110" y1="68" x2="285" y2="68" fill="#000000" stroke="#000000" stroke-width="2" stroke-dasharray="4 2 1 2"`,
+		`<line x1="290" y1="118" x2="115" y2="118" fill="#000000" stroke="#000000" stroke-width="2" stroke-dasharray="3,1.5"`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("DashPattern output does not contain %s", want)
+		}
+	}
+
+	for _, pattern := range []string{"4 dashes", "-1 2", "NaN"} {
+		s := svgsequence.NewSequence()
+		s.AddStep(svgsequence.Step{Source: "A", Target: "B", DashPattern: pattern})
+		if _, err := s.Generate(); err == nil {
+			t.Errorf("DashPattern %q: Generate() returned no error", pattern)
+		}
+	}
+}
+
+func TestStepYOffset(t *testing.T) {
+	generate := func() string {
+		s := svgsequence.NewSequence()
+		s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "first"})
+		s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "second", Color: "red", YOffset: -50})
+		s.AddStep(svgsequence.Step{Source: "B", Target: "A", Text: "third"})
+		got, err := s.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	got := generate()
+
+	// both steps land at the same height and are drawn in the order they were added
+	first := strings.Index(got, `<line x1="110" y1="68" x2="285" y2="68" fill="#000000"`)
+	second := strings.Index(got, `<line x1="110" y1="68" x2="285" y2="68" fill="red"`)
+	if first < 0 || second < 0 || first > second {
+		t.Errorf("YOffset: steps at the same height are not drawn in order, indexes %d and %d", first, second)
+	}
+	// the following steps are not moved
+	if !strings.Contains(got, `<line x1="290" y1="168" x2="115" y2="168"`) {
+		t.Errorf("YOffset moved the following step")
+	}
+	for range 5 {
+		if generate() != got {
+			t.Fatal("YOffset: the output is not stable")
+		}
+	}
+}
+
+func TestArrowStyle(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+	s.AddStep(svgsequence.Step{Source: "B", Target: "A", Style: svgsequence.Dashed})
+	s.AddStep(svgsequence.Step{Source: "B", Target: "A", Style: svgsequence.Async, Color: "red"})
+	s.AddStep(svgsequence.Step{Source: "A", Target: "A", Style: svgsequence.Async, SelfStyle: "loop"})
+	s.AddStep(svgsequence.Step{Source: "A", Target: "A", Style: svgsequence.Dashed, Color: "blue"})
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<marker id="seq-arrow-open"`,
+		`<line x1="110" y1="68" x2="285" y2="68" fill="#000000" stroke="#000000" stroke-width="2" marker-start="url(#seq-dot)" marker-end="url(#seq-arrow)"></line>`,
+		`<line x1="290" y1="118" x2="115" y2="118" fill="#000000" stroke="#000000" stroke-width="2" stroke-dasharray="4 4" marker-start="url(#seq-dot)" marker-end="url(#seq-arrow)"></line>`,
+		`<line x1="290" y1="168" x2="115" y2="168" fill="red" stroke="red" stroke-width="2" stroke-dasharray="4 4" marker-start="url(#seq-dot)" marker-end="url(#seq-arrow-open)"></line>`,
+		`<line x1="155" y1="233" x2="115" y2="233" fill="#000000" stroke="#000000" stroke-width="2" stroke-dasharray="4 4" marker-end="url(#seq-arrow-open)"></line>`,
+		// the self-message dots keep their color
+		`<circle cx="110" cy="283" r="3" fill="blue"></circle>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Style output does not contain %s", want)
+		}
+	}
+
+	// the open arrowhead is only defined when it is used
+	s = svgsequence.NewSequence()
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B", Style: svgsequence.Dashed, DashPattern: "1 2"})
+	got, err = s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(got, "seq-arrow-open") {
+		t.Errorf("output without async steps defines the open arrowhead")
+	}
+	if !strings.Contains(got, `stroke-dasharray="1 2"`) {
+		t.Errorf("DashPattern does not replace the dash pattern of the Dashed style")
+	}
+}
+
+func TestActivations(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.AddActors("A", "B")
+	s.Activate("B")
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "call"})
+	s.Activate("B")
+	s.AddStep(svgsequence.Step{Source: "B", Target: "B", Text: "reentrant", Layers: []string{"detail"}})
+	s.Deactivate("B")
+	s.AddStep(svgsequence.Step{Source: "B", Target: "A", Text: "reply"})
+	s.Deactivate("B")
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bars := []string{
+		// from the first step to the last one, centered on the lifeline
+		`<rect class="seq-activation" x="285" y="63" width="10" height="110" fill="#FFFFFF" stroke="#000000" stroke-width="1"></rect>`,
+		// the nested bar is offset to the right
+		`<rect class="seq-activation" x="290" y="113" width="10" height="10" fill="#FFFFFF" stroke="#000000" stroke-width="1"></rect>`,
+	}
+	for _, want := range bars {
+		if !strings.Contains(got, want) {
+			t.Errorf("Activate() output does not contain %s", want)
+		}
+	}
+	// drawn below the steps
+	if strings.Index(got, bars[1]) > strings.Index(got, `marker-start="url(#seq-dot)"`) {
+		t.Errorf("Activate() bars are drawn above the steps")
+	}
+
+	// the activations follow the steps of the active layers
+	s.SetActiveLayers("other")
+	got, err = s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `<rect class="seq-activation" x="285" y="63" width="10" height="60"`; !strings.Contains(got, want) {
+		t.Errorf("Activate() with layers output does not contain %s", want)
+	}
+	if n := strings.Count(got, "seq-activation"); n != 1 {
+		t.Errorf("Activate() with layers drew %d bars, want 1", n)
+	}
+
+	s = svgsequence.NewSequence()
+	s.Activate("B")
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+	if _, err := s.Generate(); err == nil || err.Error() != "found open activation of actor B" {
+		t.Errorf("open activation error = %v", err)
+	}
+	s.Deactivate("B")
+	s.Deactivate("A")
+	if _, err := s.Generate(); err == nil || err.Error() != "actor A deactivated without an activation" {
+		t.Errorf("unmatched deactivation error = %v", err)
+	}
+}
+
+func TestMessageTypesLegend(t *testing.T) {
+	newSequence := func() *svgsequence.Sequence {
+		s := svgsequence.NewSequence()
+		s.RegisterMessageType("http", "#0000AA", svgsequence.Solid, "HTTP")
+		s.RegisterMessageType("grpc", "#00AA00", svgsequence.Dashed, "gRPC")
+		s.RegisterMessageType("event", "#AA0000", svgsequence.Async, "Event")
+		s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "get", Type: "http"})
+		s.AddStep(svgsequence.Step{Source: "B", Target: "A", Text: "published", Type: "event"})
+		s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "again", Type: "http", Color: "red"})
+		return s
+	}
+
+	s := newSequence()
+	s.SetLegend(true)
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<line x1="110" y1="68" x2="285" y2="68" fill="#0000AA" stroke="#0000AA" stroke-width="2" marker-start="url(#seq-dot)" marker-end="url(#seq-arrow)"></line>`,
+		`<line x1="290" y1="118" x2="115" y2="118" fill="#AA0000" stroke="#AA0000" stroke-width="2" stroke-dasharray="4 4" marker-start="url(#seq-dot)" marker-end="url(#seq-arrow-open)"></line>`,
+		// the color of the step wins
+		`<line x1="110" y1="168" x2="285" y2="168" fill="red" stroke="red"`,
+		// the lifelines end above the legend
+		`<line class="seq-actor-line seq-actor-a" x1="110" y1="26" x2="110" y2="200"`,
+		`<line x1="20" y1="208" x2="50" y2="208" fill="#0000AA" stroke="#0000AA" stroke-width="2" marker-end="url(#seq-arrow)"></line>`,
+		`<line x1="20" y1="224" x2="50" y2="224" fill="#AA0000" stroke="#AA0000" stroke-width="2" stroke-dasharray="4 4" marker-end="url(#seq-arrow-open)"></line>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("SetLegend(true) output does not contain %s", want)
+		}
+	}
+	// each used type is listed once, in the order they were registered
+	legend := got[strings.Index(got, `<g class="seq-legend">`):]
+	http, event := strings.Index(legend, ">HTTP</text>"), strings.Index(legend, ">Event</text>")
+	if strings.Count(legend, ">HTTP</text>") != 1 || strings.Count(legend, ">Event</text>") != 1 || http > event {
+		t.Errorf("SetLegend(true) does not list HTTP and Event once and in order")
+	}
+	if strings.Contains(legend, "gRPC") {
+		t.Errorf("SetLegend(true) lists an unused message type")
+	}
+
+	s = newSequence()
+	canonical := s.Canonical()
+	got, err = s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(got, "seq-legend") || !strings.Contains(got, `viewBox="0 0 400 200"`) {
+		t.Errorf("output without legend draws it or reserves its height")
+	}
+	// the color of the message type is not written into the steps
+	if s.Canonical() != canonical {
+		t.Errorf("Generate() changed the canonical form of the sequence")
+	}
+
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B", Type: "smtp"})
+	if _, err := s.Generate(); err == nil || err.Error() != `step #4 references an unknown message type: "smtp"` {
+		t.Errorf("unknown message type error = %v", err)
+	}
+}
+
+func TestStepSpacing(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+	s.AddStep(svgsequence.Step{Source: "B", Target: "A", SpaceBefore: 20, SpaceAfter: 30})
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<line x1="110" y1="68" x2="285" y2="68"`,
+		// 50px step height plus the space before it
+		`<line x1="290" y1="138" x2="115" y2="138"`,
+		// the space after the previous step
+		`<line x1="110" y1="218" x2="285" y2="218"`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("step spacing output does not contain %s", want)
+		}
+	}
+
+	s = svgsequence.NewSequence()
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B", SpaceAfter: -1})
+	if _, err := s.Generate(); err == nil {
+		t.Errorf("negative SpaceAfter: Generate() returned no error")
+	}
+}
+
+func TestSetSelfStyle(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.SetSelfStyle("loop")
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+	s.OpenSection("retry", nil)
+	s.AddStep(svgsequence.Step{Source: "B", Target: "B", Text: "again"})
+	s.CloseSection()
+	s.AddStep(svgsequence.Step{Source: "B", Target: "B", SelfStyle: "dot"})
+	s.AddStep(svgsequence.Step{Source: "B", Target: "A"})
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		// the loop is a quarter of the distance wide with the description at its right
+		`<line x1="290" y1="118" x2="335" y2="118" fill="#000000" stroke="#000000" stroke-width="2" marker-start="url(#seq-dot)"></line>`,
+		`<line x1="335" y1="118" x2="335" y2="133" fill="#000000" stroke="#000000" stroke-width="2"></line>`,
+		`<line x1="335" y1="133" x2="295" y2="133" fill="#000000" stroke="#000000" stroke-width="2" marker-end="url(#seq-arrow)"></line>`,
+		`<text class="seq-desc" x="340" y="129" fill="#000000" stroke="none" font-size="10" text-anchor="start">again</text>`,
+		// the section covers the whole loop
+		`<rect x="200" y="95" width="180" height="51"`,
+		// the style of the step wins
+		`<circle cx="290" cy="183" r="3" fill="#000000"></circle>`,
+		// the next steps leave room for the loop
+		`<line x1="290" y1="233" x2="115" y2="233"`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("SetSelfStyle(\"loop\") output does not contain %s", want)
+		}
+	}
+}
+
+func TestSelfLoopHeight(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.SetSelfLoopHeight(30)
+	for range 3 {
+		s.AddStep(svgsequence.Step{Source: "A", Target: "A", SelfStyle: "loop", Text: "retry"})
+	}
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the vertical line of each loop
+	loops := regexp.MustCompile(`<line x1="155" y1="(\d+)" x2="155" y2="(\d+)"`).FindAllStringSubmatch(got, -1)
+	if len(loops) != 3 {
+		t.Fatalf("SetSelfLoopHeight(30) output contains %d loops, want 3", len(loops))
+	}
+	prevY2 := 0
+	for i, m := range loops {
+		y1, _ := strconv.Atoi(m[1])
+		y2, _ := strconv.Atoi(m[2])
+		if y2-y1 != 30 {
+			t.Errorf("loop %d height = %d, want 30", i+1, y2-y1)
+		}
+		if y1 <= prevY2 {
+			t.Errorf("loop %d starts at %d, overlapping the previous loop ending at %d", i+1, y1, prevY2)
+		}
+		prevY2 = y2
+	}
+	if want := `<line x1="110" y1="308" x2="285" y2="308"`; !strings.Contains(got, want) {
+		t.Errorf("SetSelfLoopHeight(30) output does not contain %s", want)
+	}
+
+	// the non-positive heights are ignored
+	for _, h := range []int{0, -10} {
+		s.SetSelfLoopHeight(h)
+		got, err := s.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := `<line x1="110" y1="308" x2="285" y2="308"`; !strings.Contains(got, want) {
+			t.Errorf("SetSelfLoopHeight(%d) output does not contain %s", h, want)
+		}
+	}
+}
+
+func TestLint(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.AddActors("Unused")
+	s.AddStep(svgsequence.Step{Source: "Client", Target: "API", Text: "request"})
+	s.AddStep(svgsequence.Step{Source: "API", Target: "DB", Text: "query"})
+	s.AddStep(svgsequence.Step{Source: "API", Target: "Client", Text: "response"})
+	s.AddStep(svgsequence.Step{Source: "Cron", Target: "API", Text: "tick"})
+	s.AddStep(svgsequence.Step{Source: "Worker", Target: "Worker", Text: "process"})
+	s.AddNote("notes do not count", "Client", "Auditor")
+	s.AddDecision("DB", "found?")
+
+	want := []string{
+		`actor "DB" only receives messages`,
+		`actor "Cron" only sends messages`,
+	}
+	if got := s.Lint(); !slices.Equal(got, want) {
+		t.Errorf("Lint() = %q, want %q", got, want)
+	}
+
+	s = svgsequence.NewSequence()
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+	s.AddStep(svgsequence.Step{Source: "B", Target: "A"})
+	if got := s.Lint(); len(got) != 0 {
+		t.Errorf("Lint() = %q, want no issues", got)
+	}
+}
+
+func TestSetSectionMargin(t *testing.T) {
+	tests := []struct {
+		margin int
+		height int
+	}{
+		{10, 36}, // default
+		{0, 46},
+		{-10, 56}, // overlapping the next section
+	}
+	for _, tt := range tests {
+		s := svgsequence.NewSequence()
+		s.SetSectionMargin(tt.margin)
+		s.OpenSection("first", nil)
+		s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+		s.CloseSection()
+		s.OpenSection("second", nil)
+		s.AddStep(svgsequence.Step{Source: "B", Target: "A"})
+		s.CloseSection()
+		got, err := s.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, y := range []int{45, 95} {
+			want := fmt.Sprintf(`<rect x="20" y="%d" width="360" height="%d"`, y, tt.height)
+			if !strings.Contains(got, want) {
+				t.Errorf("SetSectionMargin(%d) output does not contain %s", tt.margin, want)
+			}
+		}
+
+		// the sections are computed again on each generation
+		again, err := s.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again != got {
+			t.Errorf("SetSectionMargin(%d) output changed when generated again", tt.margin)
+		}
+	}
+}
+
+func TestAddBackReference(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "request"})
+	s.AddStep(svgsequence.Step{Source: "B", Target: "A", Text: "error"})
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "request"})
+	s.AddBackReference(2, 0, "retry")
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		// from the third step up to the first one, left of the lifeline of A
+		`<path class="seq-back-reference" d="M 105 168 C 60 168 60 68 105 68" fill="none" stroke="#000000" stroke-width="2" marker-end="url(#seq-arrow)"></path>`,
+		`<text class="seq-desc" x="67.25" y="121" fill="#000000" stroke="none" font-size="10" text-anchor="end">retry</text>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("AddBackReference() output does not contain %s", want)
+		}
+	}
+
+	for _, r := range [][2]int{{0, 2}, {1, 1}, {3, 0}, {2, -1}} {
+		s := svgsequence.NewSequence()
+		s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+		s.AddStep(svgsequence.Step{Source: "B", Target: "A"})
+		s.AddStep(svgsequence.Step{Source: "A", Target: "B"})
+		s.AddBackReference(r[0], r[1], "")
+		if _, err := s.Generate(); err == nil {
+			t.Errorf("AddBackReference(%d, %d) Generate() returned no error", r[0], r[1])
+		}
+	}
+}
+
+func TestAddBackReferenceWithLayers(t *testing.T) {
+	s := svgsequence.NewSequence()
+	s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "debug", Layers: []string{"detail"}})
+	s.AddStep(svgsequence.Step{Source: "B", Target: "C", Text: "request"})
+	s.AddStep(svgsequence.Step{Source: "C", Target: "B", Text: "error"})
+	s.AddStep(svgsequence.Step{Source: "B", Target: "C", Text: "request"})
+	s.AddBackReference(3, 1, "retry")
+	// its target is not generated
+	s.AddBackReference(3, 0, "dropped")
+	s.SetActiveLayers("other")
+	got, err := s.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// from the third generated step up to the first one, left of the lifeline of B
+	if want := `<path class="seq-back-reference" d="M 105 168 C 60 168 60 68 105 68"`; !strings.Contains(got, want) {
+		t.Errorf("AddBackReference() with layers output does not contain %s", want)
+	}
+	if n := strings.Count(got, "seq-back-reference"); n != 1 || strings.Contains(got, "dropped") {
+		t.Errorf("AddBackReference() with layers drew %d references, want 1", n)
+	}
+}
+
+func TestSetDescriptionItalic(t *testing.T) {
+	for _, italic := range []bool{false, true} {
+		s := svgsequence.NewSequence()
+		s.SetDescriptionItalic(italic)
+		s.AddStep(svgsequence.Step{Source: "A", Target: "B", Text: "request"})
+		s.AddNote("noted", "B")
+		got, err := s.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, desc := range []string{
+			`font-size="10" font-style="italic" text-anchor="middle">request</text>`,
+			`font-size="10" font-style="italic" text-anchor="middle">noted</text>`,
+		} {
+			if strings.Contains(got, desc) != italic {
+				t.Errorf("SetDescriptionItalic(%v) output contains %s = %v", italic, desc, !italic)
+			}
+		}
+		// the actor names stay upright
+		if want := `font-size="16" text-anchor="middle">A</text>`; !strings.Contains(got, want) {
+			t.Errorf("SetDescriptionItalic(%v) output does not contain %s", italic, want)
+		}
+	}
+}